@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// Access log formats
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+	AccessLogTemplate = "template"
+)
+
+// Access log destinations other than a file path
+const (
+	AccessLogStdout = "stdout"
+	AccessLogStderr = "stderr"
+)
+
+// AccessLogConfig holds the access log settings
+type AccessLogConfig struct {
+	Format         string
+	Template       string // text/template over AccessLogEntry, for the template format
+	Path           string // file path, "stdout" or "stderr"
+	MaxSize        int64  // bytes before a file is rotated, 0 for no limit
+	RotateInterval time.Duration
+	MaxBackups     int // rotated files kept, 0 keeps all
+}
+
+// AccessLogEntry describes a completed request
+type AccessLogEntry struct {
+	Time            time.Time     `json:"time"`
+	RequestID       string        `json:"request_id"`
+	TraceID         string        `json:"trace_id,omitempty"`
+	ClientIP        string        `json:"client_ip"`
+	Method          string        `json:"method"`
+	URI             string        `json:"uri"`
+	Protocol        string        `json:"protocol"`
+	Host            string        `json:"host"`
+	Status          int           `json:"status"`
+	BytesSent       int64         `json:"bytes_sent"`
+	Duration        time.Duration `json:"-"`
+	UserAgent       string        `json:"user_agent"`
+	Referer         string        `json:"referer"`
+	Route           string        `json:"route"`
+	Upstream        string        `json:"upstream"`
+	UpstreamLatency time.Duration `json:"-"`
+	CacheStatus     string        `json:"cache_status"`
+	RateLimited     bool          `json:"rate_limited"`
+}
+
+// MarshalJSON writes durations as fractional milliseconds
+func (e AccessLogEntry) MarshalJSON() ([]byte, error) {
+	type entry AccessLogEntry
+	return json.Marshal(struct {
+		entry
+		DurationMs        float64 `json:"duration_ms"`
+		UpstreamLatencyMs float64 `json:"upstream_latency_ms"`
+	}{entry(e), milliseconds(e.Duration), milliseconds(e.UpstreamLatency)})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// AccessLogger writes one line per request in the configured format
+type AccessLogger struct {
+	config   AccessLogConfig
+	out      io.Writer
+	file     *rotatingFile // nil when writing to stdout or stderr
+	template *template.Template
+}
+
+// NewAccessLogger opens the access log destination described by config
+func NewAccessLogger(config AccessLogConfig) (*AccessLogger, error) {
+	l := &AccessLogger{config: config}
+
+	if config.Format == AccessLogTemplate {
+		tmpl, err := parseAccessLogTemplate(config.Template)
+		if err != nil {
+			return nil, err
+		}
+		l.template = tmpl
+	}
+
+	switch config.Path {
+	case "", AccessLogStdout:
+		l.out = os.Stdout
+	case AccessLogStderr:
+		l.out = os.Stderr
+	default:
+		file, err := openRotatingFile(config.Path, config.MaxSize, config.RotateInterval, config.MaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %v", err)
+		}
+		l.file = file
+		l.out = file
+	}
+
+	return l, nil
+}
+
+// parseAccessLogTemplate parses a user-supplied access log template,
+// checking that it only refers to AccessLogEntry fields
+func parseAccessLogTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("access_log").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, AccessLogEntry{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Log writes an entry as a single line
+func (l *AccessLogger) Log(entry AccessLogEntry) {
+	var buf bytes.Buffer
+	switch l.config.Format {
+	case AccessLogJSON:
+		json.NewEncoder(&buf).Encode(entry)
+	case AccessLogTemplate:
+		if err := l.template.Execute(&buf, entry); err != nil {
+			slog.Error("access log template failed", "error", err)
+			return
+		}
+		buf.WriteByte('\n')
+	case AccessLogCommon:
+		writeCommonLogFormat(&buf, entry)
+		buf.WriteByte('\n')
+	default:
+		writeCommonLogFormat(&buf, entry)
+		fmt.Fprintf(&buf, " %s %s\n", quoteLogField(entry.Referer), quoteLogField(entry.UserAgent))
+	}
+	l.out.Write(buf.Bytes())
+}
+
+// Close closes the access log file, if any. It is safe to call on nil.
+func (l *AccessLogger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// writeCommonLogFormat writes host ident authuser [date] "request" status bytes
+func writeCommonLogFormat(buf *bytes.Buffer, e AccessLogEntry) {
+	bytesSent := "-"
+	if e.BytesSent > 0 {
+		bytesSent = strconv.FormatInt(e.BytesSent, 10)
+	}
+	fmt.Fprintf(buf, "%s - - [%s] %s %d %s",
+		orDash(e.ClientIP), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quoteLogField(e.Method+" "+e.URI+" "+e.Protocol), e.Status, bytesSent)
+}
+
+// quoteLogField quotes a value for CLF, writing "-" for empty values
+func quoteLogField(s string) string {
+	return strconv.Quote(orDash(s))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// requestURI returns the request target as the client sent it
+func requestURI(r *http.Request) string {
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+	return r.URL.RequestURI()
+}
+
+// traceID returns the ID of the request's trace, or "" if it is not traced
+func traceID(r *http.Request) string {
+	if span := spanFromContext(r.Context()); span != nil {
+		return span.Context().TraceID.String()
+	}
+	return ""
+}
+
+// accessLogMiddleware writes an access log entry for every request
+func accessLogMiddleware(logger *AccessLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r)
+		start := time.Now()
+
+		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(lrw, r)
+
+		snapshot := info.snapshot()
+		logger.Log(AccessLogEntry{
+			Time:            start,
+			RequestID:       snapshot.ID,
+			TraceID:         traceID(r),
+			ClientIP:        getClientKey(r),
+			Method:          r.Method,
+			URI:             requestURI(r),
+			Protocol:        r.Proto,
+			Host:            r.Host,
+			Status:          lrw.statusCode,
+			BytesSent:       lrw.bytes,
+			Duration:        time.Since(start),
+			UserAgent:       r.UserAgent(),
+			Referer:         r.Referer(),
+			Route:           snapshot.Route,
+			Upstream:        snapshot.Backend,
+			UpstreamLatency: snapshot.UpstreamLatency,
+			CacheStatus:     snapshot.CacheStatus,
+			RateLimited:     snapshot.RateLimited,
+		})
+	})
+}
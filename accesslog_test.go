@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAccessLogEntry() AccessLogEntry {
+	return AccessLogEntry{
+		Time:            time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		RequestID:       "abc123",
+		ClientIP:        "203.0.113.7",
+		Method:          "GET",
+		URI:             "/users?page=2",
+		Protocol:        "HTTP/1.1",
+		Host:            "api.example.com",
+		Status:          200,
+		BytesSent:       512,
+		Duration:        1500 * time.Microsecond,
+		UserAgent:       "curl/8.0",
+		Referer:         "https://example.com/",
+		Route:           "api",
+		Upstream:        "10.0.0.5:8080",
+		UpstreamLatency: time.Millisecond,
+		CacheStatus:     "MISS",
+	}
+}
+
+// logToBuffer returns an access logger for format whose output goes to a buffer
+func logToBuffer(t *testing.T, format, tmpl string) (*AccessLogger, *bytes.Buffer) {
+	logger, err := NewAccessLogger(AccessLogConfig{Format: format, Template: tmpl})
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	logger.out = buf
+	return logger, buf
+}
+
+func TestAccessLogger_Common(t *testing.T) {
+	logger, buf := logToBuffer(t, AccessLogCommon, "")
+	logger.Log(newTestAccessLogEntry())
+
+	assert.Equal(t, `203.0.113.7 - - [01/May/2024:12:00:00 +0000] "GET /users?page=2 HTTP/1.1" 200 512`+"\n", buf.String())
+}
+
+func TestAccessLogger_Combined(t *testing.T) {
+	logger, buf := logToBuffer(t, AccessLogCombined, "")
+	entry := newTestAccessLogEntry()
+	entry.BytesSent = 0
+	entry.Referer = ""
+	logger.Log(entry)
+
+	assert.Equal(t, `203.0.113.7 - - [01/May/2024:12:00:00 +0000] "GET /users?page=2 HTTP/1.1" 200 - "-" "curl/8.0"`+"\n", buf.String())
+}
+
+func TestAccessLogger_JSON(t *testing.T) {
+	logger, buf := logToBuffer(t, AccessLogJSON, "")
+	logger.Log(newTestAccessLogEntry())
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "abc123", line["request_id"])
+	assert.Equal(t, "10.0.0.5:8080", line["upstream"])
+	assert.Equal(t, "MISS", line["cache_status"])
+	assert.Equal(t, false, line["rate_limited"])
+	assert.Equal(t, float64(512), line["bytes_sent"])
+	assert.Equal(t, 1.5, line["duration_ms"])
+	assert.Equal(t, 1.0, line["upstream_latency_ms"])
+	assert.True(t, strings.HasSuffix(buf.String(), "}\n"))
+}
+
+func TestAccessLogger_Template(t *testing.T) {
+	logger, buf := logToBuffer(t, AccessLogTemplate, "{{.RequestID}} {{.Route}} {{.Status}} {{.UpstreamLatency}}")
+	logger.Log(newTestAccessLogEntry())
+
+	assert.Equal(t, "abc123 api 200 1ms\n", buf.String())
+}
+
+func TestNewAccessLogger_InvalidTemplate(t *testing.T) {
+	_, err := NewAccessLogger(AccessLogConfig{Format: AccessLogTemplate, Template: "{{.Nope}}"})
+	assert.Error(t, err)
+
+	_, err = NewAccessLogger(AccessLogConfig{Format: AccessLogTemplate, Template: "{{"})
+	assert.Error(t, err)
+}
+
+func TestNewAccessLogger_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger, err := NewAccessLogger(AccessLogConfig{Format: AccessLogCommon, Path: path})
+	assert.NoError(t, err)
+
+	logger.Log(newTestAccessLogEntry())
+	assert.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"GET /users?page=2 HTTP/1.1" 200 512`)
+}
+
+func TestAccessLogMiddleware_RecordsRequestDetails(t *testing.T) {
+	logger, buf := logToBuffer(t, AccessLogJSON, "")
+	handler := accessLogMiddleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := getRequestInfo(r.Context())
+		info.setRoute("api")
+		info.setBackend("10.0.0.5:8080")
+		info.setUpstreamLatency(2 * time.Millisecond)
+		info.setCacheStatus("MISS")
+		info.setRateLimited()
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("slow down"))
+	}))
+
+	req := httptest.NewRequest("GET", "/users?page=2", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	req.Header.Set("User-Agent", "curl/8.0")
+	req.Header.Set("Referer", "https://example.com/")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Len(t, line["request_id"], 32)
+	assert.Equal(t, "203.0.113.7", line["client_ip"])
+	assert.Equal(t, "/users?page=2", line["uri"])
+	assert.Equal(t, float64(429), line["status"])
+	assert.Equal(t, float64(9), line["bytes_sent"])
+	assert.Equal(t, "curl/8.0", line["user_agent"])
+	assert.Equal(t, "https://example.com/", line["referer"])
+	assert.Equal(t, "api", line["route"])
+	assert.Equal(t, "10.0.0.5:8080", line["upstream"])
+	assert.Equal(t, 2.0, line["upstream_latency_ms"])
+	assert.Equal(t, "MISS", line["cache_status"])
+	assert.Equal(t, true, line["rate_limited"])
+}
+
+func TestProxy_AccessLogReplacesRequestLogging(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := newTestBackendServer(t, "ok")
+	config := newTestReloadConfig(backend.URL)
+	config.AccessLogEnabled = true
+	config.AccessLogFormat = AccessLogCombined
+	config.AccessLogPath = filepath.Join(t.TempDir(), "access.log")
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	defer proxy.Close(nil)
+
+	assert.Equal(t, "ok", serveBody(proxy, "/hello"))
+	assert.NotContains(t, helper.GetLogs(), "/hello")
+
+	data, err := os.ReadFile(config.AccessLogPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"GET /hello HTTP/1.1" 200 2`)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig holds the settings for obtaining certificates via ACME
+type ACMEConfig struct {
+	DirectoryURL  string   // ACME directory, such as Let's Encrypt or a local Pebble server
+	Email         string   // contact address registered with the account; may be empty
+	Domains       []string // host names certificates are requested for
+	CacheDir      string   // directory storing the account key and certificates
+	CAFile        string   // PEM bundle trusted for the directory; empty uses the system roots
+	HTTPChallenge bool     // answer HTTP-01 challenges on the plain HTTP listener
+}
+
+// newACMEManager creates the certificate manager. Certificates are obtained
+// on the first handshake for a domain and renewed before they expire.
+func newACMEManager(config ACMEConfig) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: config.DirectoryURL}
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ACME CA bundle: %v", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.CacheDir),
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Email:      config.Email,
+		Client:     client,
+	}, nil
+}
+
+// acmeGetCertificate serves a certificate from certs when one is valid for
+// the client's SNI name and obtains one via ACME otherwise. certs may be nil.
+func acmeGetCertificate(manager *autocert.Manager, certs *certStore) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if certs != nil && !isACMEChallenge(hello) {
+			if cert := certs.match(hello); cert != nil {
+				return cert, nil
+			}
+		}
+		return manager.GetCertificate(hello)
+	}
+}
+
+// isACMEChallenge reports whether the handshake is a TLS-ALPN-01 challenge
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// withACMEChallenge lets the TLS listener answer TLS-ALPN-01 challenges
+func withACMEChallenge(config *tls.Config) {
+	config.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func newTestACMEManager(t *testing.T) *autocert.Manager {
+	manager, err := newACMEManager(ACMEConfig{
+		DirectoryURL: "https://127.0.0.1:14000/dir",
+		Email:        "ops@example.com",
+		Domains:      []string{"example.com", "www.example.com"},
+		CacheDir:     filepath.Join(t.TempDir(), "acme"),
+	})
+	assert.NoError(t, err)
+	return manager
+}
+
+func TestNewACMEManager(t *testing.T) {
+	manager := newTestACMEManager(t)
+
+	assert.Equal(t, "https://127.0.0.1:14000/dir", manager.Client.DirectoryURL)
+	assert.Equal(t, "ops@example.com", manager.Email)
+	assert.IsType(t, autocert.DirCache(""), manager.Cache)
+	assert.NoError(t, manager.HostPolicy(context.Background(), "www.example.com"))
+	assert.Error(t, manager.HostPolicy(context.Background(), "evil.test"))
+
+	ca := writeTestCertificate(t, t.TempDir(), "pebble", "localhost")
+	manager, err := newACMEManager(ACMEConfig{DirectoryURL: "https://localhost:14000/dir", CAFile: ca.CertFile, CacheDir: t.TempDir()})
+	assert.NoError(t, err)
+	assert.NotNil(t, manager.Client.HTTPClient, "a custom CA gets its own client")
+
+	_, err = newACMEManager(ACMEConfig{CAFile: "missing.pem"})
+	assert.ErrorContains(t, err, "failed to load ACME CA bundle")
+}
+
+func TestACMEGetCertificate(t *testing.T) {
+	store, err := newCertStore([]TLSCertificateConfig{writeTestCertificate(t, t.TempDir(), "static", "static.example.com")})
+	assert.NoError(t, err)
+	getCertificate := acmeGetCertificate(newTestACMEManager(t), store)
+
+	hello := func(name string, protos ...string) *tls.ClientHelloInfo {
+		return &tls.ClientHelloInfo{
+			ServerName:        name,
+			SupportedProtos:   protos,
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		}
+	}
+
+	// Configured certificates take precedence
+	cert, err := getCertificate(hello("static.example.com", "h2"))
+	assert.NoError(t, err)
+	assert.Same(t, store.match(hello("static.example.com")), cert)
+
+	// Other names are left to the ACME manager, which refuses unlisted hosts
+	_, err = getCertificate(hello("evil.test"))
+	assert.ErrorContains(t, err, "not configured in HostWhitelist")
+
+	// TLS-ALPN-01 challenges always go to the manager
+	_, err = getCertificate(hello("static.example.com", acme.ALPNProto))
+	assert.Error(t, err)
+}
+
+func TestACMEHTTPChallengeFallback(t *testing.T) {
+	handler := newTestACMEManager(t).HTTPHandler(httpsRedirectHandler(443))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/page", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, rr.Code)
+	assert.Equal(t, "https://example.com/page", rr.Header().Get("Location"))
+
+	// Unknown challenge tokens are not redirected
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestWithACMEChallenge(t *testing.T) {
+	config := &tls.Config{}
+	withACMEChallenge(config)
+	assert.Equal(t, []string{"h2", "http/1.1", acme.ALPNProto}, config.NextProtos)
+}
+
+// fakeACMEToken is the token of the fake CA's only HTTP-01 challenge
+const fakeACMEToken = "fake-token"
+
+// fakeACMEServer is a minimal RFC 8555 certificate authority handling a
+// single account and order. It validates the order's HTTP-01 challenge by
+// fetching the key authorization from challengeAddr and issues certificates
+// signed by ca.
+type fakeACMEServer struct {
+	*httptest.Server
+	t             *testing.T
+	ca            tls.Certificate
+	challengeAddr string
+
+	mu         sync.Mutex
+	nonce      int
+	thumbprint string // of the account key
+	domain     string
+	validated  bool
+	chain      []byte // PEM certificate chain, once issued
+}
+
+func newFakeACMEServer(t *testing.T, ca tls.Certificate) *fakeACMEServer {
+	s := &fakeACMEServer{t: t, ca: ca}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /dir", s.handleDirectory)
+	mux.HandleFunc("/new-nonce", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /new-account", s.handleNewAccount)
+	mux.HandleFunc("POST /new-order", s.handleNewOrder)
+	mux.HandleFunc("POST /order", s.handleOrder)
+	mux.HandleFunc("POST /authz", s.handleAuthorization)
+	mux.HandleFunc("POST /challenge", s.handleChallenge)
+	mux.HandleFunc("POST /finalize", s.handleFinalize)
+	mux.HandleFunc("POST /cert", s.handleCertificate)
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.nonce++
+		w.Header().Set("Replay-Nonce", "nonce-"+strconv.Itoa(s.nonce))
+		s.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// readJWS decodes the payload of a JWS-signed request into payload, if not
+// nil, and returns the account key embedded in the request, if any
+func (s *fakeACMEServer) readJWS(r *http.Request, payload any) json.RawMessage {
+	var jws struct{ Protected, Payload string }
+	assert.NoError(s.t, json.NewDecoder(r.Body).Decode(&jws))
+	var protected struct {
+		JWK json.RawMessage
+		URL string
+	}
+	data, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	assert.NoError(s.t, json.Unmarshal(data, &protected))
+	assert.Equal(s.t, s.URL+r.URL.Path, protected.URL)
+	if payload != nil {
+		data, _ = base64.RawURLEncoding.DecodeString(jws.Payload)
+		assert.NoError(s.t, json.Unmarshal(data, payload))
+	}
+	return protected.JWK
+}
+
+func (s *fakeACMEServer) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"newNonce":   s.URL + "/new-nonce",
+		"newAccount": s.URL + "/new-account",
+		"newOrder":   s.URL + "/new-order",
+		"revokeCert": s.URL + "/revoke-cert",
+		"keyChange":  s.URL + "/key-change",
+	})
+}
+
+func (s *fakeACMEServer) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	var key struct{ Crv, Kty, X, Y string }
+	assert.NoError(s.t, json.Unmarshal(s.readJWS(r, nil), &key))
+	sum := sha256.Sum256(fmt.Appendf(nil, `{"crv":%q,"kty":%q,"x":%q,"y":%q}`, key.Crv, key.Kty, key.X, key.Y))
+
+	s.mu.Lock()
+	s.thumbprint = base64.RawURLEncoding.EncodeToString(sum[:])
+	s.mu.Unlock()
+	w.Header().Set("Location", s.URL+"/account")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`{"status":"valid"}`))
+}
+
+func (s *fakeACMEServer) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	var order struct{ Identifiers []struct{ Value string } }
+	s.readJWS(r, &order)
+	assert.Len(s.t, order.Identifiers, 1)
+
+	s.mu.Lock()
+	s.domain = order.Identifiers[0].Value
+	s.mu.Unlock()
+	s.writeOrder(w, http.StatusCreated)
+}
+
+func (s *fakeACMEServer) handleOrder(w http.ResponseWriter, r *http.Request) {
+	s.readJWS(r, nil)
+	s.writeOrder(w, http.StatusOK)
+}
+
+func (s *fakeACMEServer) writeOrder(w http.ResponseWriter, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order := map[string]any{
+		"status":         "pending",
+		"identifiers":    []map[string]string{{"type": "dns", "value": s.domain}},
+		"authorizations": []string{s.URL + "/authz"},
+		"finalize":       s.URL + "/finalize",
+	}
+	switch {
+	case s.chain != nil:
+		order["status"] = "valid"
+		order["certificate"] = s.URL + "/cert"
+	case s.validated:
+		order["status"] = "ready"
+	}
+	w.Header().Set("Location", s.URL+"/order")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(order)
+}
+
+func (s *fakeACMEServer) handleAuthorization(w http.ResponseWriter, r *http.Request) {
+	s.readJWS(r, nil)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := "pending"
+	if s.validated {
+		status = "valid"
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": s.domain},
+		"challenges": []map[string]string{{"type": "http-01", "url": s.URL + "/challenge", "token": fakeACMEToken, "status": status}},
+	})
+}
+
+// handleChallenge validates the HTTP-01 challenge the way a CA does, by
+// requesting the key authorization from the domain's HTTP listener
+func (s *fakeACMEServer) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	s.readJWS(r, nil)
+	s.mu.Lock()
+	domain, want := s.domain, fakeACMEToken+"."+s.thumbprint
+	s.mu.Unlock()
+
+	req, err := http.NewRequest("GET", "http://"+s.challengeAddr+"/.well-known/acme-challenge/"+fakeACMEToken, nil)
+	assert.NoError(s.t, err)
+	req.Host = domain
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(s.t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	keyAuth, _ := io.ReadAll(resp.Body)
+
+	s.mu.Lock()
+	s.validated = string(keyAuth) == want
+	s.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{"type": "http-01", "url": s.URL + "/challenge", "token": fakeACMEToken, "status": "valid"})
+}
+
+func (s *fakeACMEServer) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	var finalize struct{ CSR string }
+	s.readJWS(r, &finalize)
+	der, _ := base64.RawURLEncoding.DecodeString(finalize.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if !assert.NoError(s.t, err) {
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, s.ca.Leaf, csr.PublicKey, s.ca.PrivateKey)
+	assert.NoError(s.t, err)
+
+	s.mu.Lock()
+	s.chain = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Leaf.Raw})...)
+	s.mu.Unlock()
+	s.writeOrder(w, http.StatusOK)
+}
+
+func (s *fakeACMEServer) handleCertificate(w http.ResponseWriter, r *http.Request) {
+	s.readJWS(r, nil)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(s.chain)
+}
+
+func TestACMEIssuesCertificateOverHTTP01(t *testing.T) {
+	dir := t.TempDir()
+	caFiles := writeTestCertificate(t, dir, "fake-ca", "ca.test")
+	ca, err := tls.LoadX509KeyPair(caFiles.CertFile, caFiles.KeyFile)
+	assert.NoError(t, err)
+	directory := newFakeACMEServer(t, ca)
+
+	// Trust the directory's certificate through the CA bundle setting
+	directoryCA := filepath.Join(dir, "directory.pem")
+	assert.NoError(t, os.WriteFile(directoryCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: directory.Certificate().Raw}), 0o600))
+	manager, err := newACMEManager(ACMEConfig{
+		DirectoryURL:  directory.URL + "/dir",
+		Domains:       []string{"example.com"},
+		CacheDir:      filepath.Join(dir, "acme"),
+		CAFile:        directoryCA,
+		HTTPChallenge: true,
+	})
+	assert.NoError(t, err)
+
+	// The plain HTTP listener answers the challenge; the TLS listener
+	// obtains the certificate on the first handshake for the domain
+	challenges := httptest.NewServer(manager.HTTPHandler(httpsRedirectHandler(443)))
+	defer challenges.Close()
+	directory.challengeAddr = challenges.Listener.Addr().String()
+
+	tlsConfig, err := newServerTLSConfig(TLSConfig{MinVersion: tls.VersionTLS12}, acmeGetCertificate(manager, nil))
+	assert.NoError(t, err)
+	withACMEChallenge(tlsConfig)
+	addr := startHTTP2Server(t, protoHandler, HTTP2Config{Enabled: true}, tlsConfig)
+
+	roots, err := loadCertPool(caFiles.CertFile)
+	assert.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get("https://example.com/")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	directory.mu.Lock()
+	assert.True(t, directory.validated, "the HTTP-01 challenge was answered")
+	directory.mu.Unlock()
+	leaf := resp.TLS.PeerCertificates[0]
+	assert.Equal(t, []string{"example.com"}, leaf.DNSNames)
+	assert.Equal(t, ca.Leaf.Subject, leaf.Issuer)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// AdminHandler serves the operator-only admin API. It is meant to be served
+// on its own listener, separate from proxied traffic.
+type AdminHandler struct {
+	proxy func() *Proxy // returns the proxy currently serving traffic
+	mux   *http.ServeMux
+}
+
+// RouteBackendsStatus lists the backends of a route
+type RouteBackendsStatus struct {
+	Route    string          `json:"route"`
+	Backends []BackendStatus `json:"backends"`
+}
+
+// RateLimiterStatus is a snapshot of a rate limiter
+type RateLimiterStatus struct {
+	Buckets           int     `json:"buckets"`
+	TotalTokens       float64 `json:"total_tokens"`
+	RequestsPerMinute int     `json:"requests_per_minute"`
+	BurstSize         int     `json:"burst_size"`
+}
+
+// CacheStatus is a snapshot of a cache including its hit ratio
+type CacheStatus struct {
+	CacheStats
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// adminBackendRequest selects a backend by URL or host:port, optionally
+// limited to a single route
+type adminBackendRequest struct {
+	Backend string `json:"backend"`
+	Route   string `json:"route"`
+}
+
+// adminPurgeRequest selects cache entries to purge, optionally limited to a
+// single cache
+type adminPurgeRequest struct {
+	Cache string   `json:"cache"`
+	Keys  []string `json:"keys"`
+	All   bool     `json:"all"`
+}
+
+// adminResetRequest selects a client's rate limit bucket, optionally limited
+// to a single limiter
+type adminResetRequest struct {
+	Client  string `json:"client"`
+	Limiter string `json:"limiter"`
+}
+
+// NewAdminHandler creates the admin API for the proxy returned by proxy,
+// which is called on every request so reloads are picked up
+func NewAdminHandler(proxy func() *Proxy) *AdminHandler {
+	a := &AdminHandler{proxy: proxy, mux: http.NewServeMux()}
+
+	a.mux.Handle("/config", allowMethod(http.MethodGet, a.handleConfig))
+	a.mux.Handle("/backends", allowMethod(http.MethodGet, a.handleBackends))
+	a.mux.Handle("/backends/drain", allowMethod(http.MethodPost, a.handleDrain(true)))
+	a.mux.Handle("/backends/undrain", allowMethod(http.MethodPost, a.handleDrain(false)))
+	a.mux.Handle("/cache", allowMethod(http.MethodGet, a.handleCache))
+	a.mux.Handle("/cache/purge", allowMethod(http.MethodPost, a.handlePurge))
+	a.mux.Handle("/ratelimit", allowMethod(http.MethodGet, a.handleRateLimit))
+	a.mux.Handle("/ratelimit/reset", allowMethod(http.MethodPost, a.handleReset))
+	a.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeErrorResponse(w, r, http.StatusNotFound, "not_found", "Unknown admin endpoint")
+	})
+
+	return a
+}
+
+// ServeHTTP dispatches an admin request
+func (a *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// handleConfig returns the effective configuration
+func (a *AdminHandler) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.proxy().config)
+}
+
+// handleBackends returns the state of every backend by route
+func (a *AdminHandler) handleBackends(w http.ResponseWriter, r *http.Request) {
+	var routes []RouteBackendsStatus
+	for _, route := range a.proxy().router.Routes() {
+		routes = append(routes, RouteBackendsStatus{Route: route.Name, Backends: route.Pool.Status()})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// handleDrain takes a backend out of rotation or puts it back
+func (a *AdminHandler) handleDrain(draining bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req adminBackendRequest
+		if !decodeAdminRequest(w, r, &req) {
+			return
+		}
+		if req.Backend == "" {
+			writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "backend is required")
+			return
+		}
+
+		var updated []BackendStatus
+		for _, route := range a.proxy().router.Routes() {
+			if req.Route != "" && route.Name != req.Route {
+				continue
+			}
+			for _, backend := range route.Pool.Backends() {
+				if backend.URL.String() == req.Backend || backend.URL.Host == req.Backend {
+					backend.SetDraining(draining)
+					updated = append(updated, backend.Status())
+				}
+			}
+		}
+		if len(updated) == 0 {
+			writeErrorResponse(w, r, http.StatusNotFound, "backend_not_found", "No backend matches "+req.Backend)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, updated)
+	}
+}
+
+// handleCache returns the statistics of every cache
+func (a *AdminHandler) handleCache(w http.ResponseWriter, r *http.Request) {
+	caches := make(map[string]CacheStatus)
+	for name, cache := range a.proxy().caches() {
+		stats := cache.Stats()
+		caches[name] = CacheStatus{CacheStats: stats, HitRatio: stats.HitRatio()}
+	}
+	writeJSON(w, http.StatusOK, caches)
+}
+
+// handlePurge removes the given keys, or everything, from the caches
+func (a *AdminHandler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	var req adminPurgeRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if len(req.Keys) == 0 && !req.All {
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "keys or all is required")
+		return
+	}
+
+	caches := a.proxy().caches()
+	if req.Cache != "" {
+		cache, ok := caches[req.Cache]
+		if !ok {
+			writeErrorResponse(w, r, http.StatusNotFound, "cache_not_found", "No cache named "+req.Cache)
+			return
+		}
+		caches = map[string]*Cache{req.Cache: cache}
+	}
+
+	purged := 0
+	for _, cache := range caches {
+		if req.All {
+			purged += cache.Size()
+			cache.Clear()
+			continue
+		}
+		for _, key := range req.Keys {
+			if cache.Delete(key) {
+				purged++
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// handleRateLimit returns the state of every rate limiter
+func (a *AdminHandler) handleRateLimit(w http.ResponseWriter, r *http.Request) {
+	limiters := make(map[string]RateLimiterStatus)
+	for name, limiter := range a.proxy().rateLimiters() {
+		buckets, tokens := limiter.Stats()
+		limiters[name] = RateLimiterStatus{
+			Buckets:           buckets,
+			TotalTokens:       tokens,
+			RequestsPerMinute: limiter.rpm,
+			BurstSize:         limiter.burstSize,
+		}
+	}
+	writeJSON(w, http.StatusOK, limiters)
+}
+
+// handleReset gives a client a full rate limit bucket again
+func (a *AdminHandler) handleReset(w http.ResponseWriter, r *http.Request) {
+	var req adminResetRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Client == "" {
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "client is required")
+		return
+	}
+
+	limiters := a.proxy().rateLimiters()
+	if req.Limiter != "" {
+		limiter, ok := limiters[req.Limiter]
+		if !ok {
+			writeErrorResponse(w, r, http.StatusNotFound, "limiter_not_found", "No rate limiter named "+req.Limiter)
+			return
+		}
+		limiters = map[string]*RateLimiter{req.Limiter: limiter}
+	}
+
+	reset := 0
+	for _, limiter := range limiters {
+		if limiter.Reset(req.Client) {
+			reset++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"reset": reset})
+}
+
+// allowMethod responds with 405 to requests using any other method
+func allowMethod(method string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeErrorResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Use "+method)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// decodeAdminRequest decodes a JSON request body, responding with 400 and
+// returning false if it is malformed
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeErrorResponse(w, r, http.StatusBadRequest, "invalid_request", "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
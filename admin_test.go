@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdmin(t *testing.T) (*AdminHandler, *Proxy) {
+	config := &Config{
+		RequestTimeout:   5,
+		CacheEnabled:     true,
+		CacheSize:        10,
+		CacheTTL:         60,
+		RateLimitEnabled: true,
+		RateLimitRPM:     60,
+		RateLimitBurst:   5,
+		Routes: []RouteConfig{
+			{Name: "api", PathPrefix: "/api/", Backends: []BackendConfig{{URL: "http://api-1:8080"}, {URL: "http://api-2:8080"}}},
+			{Name: "web", PathPrefix: "/", Backends: []BackendConfig{{URL: "http://web:8080"}}},
+		},
+	}
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	return NewAdminHandler(func() *Proxy { return proxy }), proxy
+}
+
+func adminRequest(admin *AdminHandler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestAdmin_Config(t *testing.T) {
+	admin, _ := newTestAdmin(t)
+
+	w := adminRequest(admin, "GET", "/config", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var config Config
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+	assert.Len(t, config.Routes, 2)
+	assert.Equal(t, 10, config.CacheSize)
+}
+
+func TestAdmin_Backends(t *testing.T) {
+	admin, _ := newTestAdmin(t)
+
+	w := adminRequest(admin, "GET", "/backends", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var routes []RouteBackendsStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &routes))
+	assert.Len(t, routes, 2)
+	assert.Equal(t, "api", routes[0].Route)
+	assert.Len(t, routes[0].Backends, 2)
+	assert.True(t, routes[0].Backends[0].Healthy)
+}
+
+func TestAdmin_DrainBackend(t *testing.T) {
+	admin, proxy := newTestAdmin(t)
+	backend := proxy.Router().Routes()[0].Pool.Backends()[0]
+
+	w := adminRequest(admin, "POST", "/backends/drain", `{"backend":"api-1:8080"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, backend.Draining())
+	assert.False(t, backend.Available())
+
+	w = adminRequest(admin, "POST", "/backends/undrain", `{"backend":"http://api-1:8080"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, backend.Draining())
+
+	w = adminRequest(admin, "POST", "/backends/drain", `{"backend":"api-1:8080","route":"web"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"backend_not_found"`)
+}
+
+func TestAdmin_CacheStatsAndPurge(t *testing.T) {
+	admin, proxy := newTestAdmin(t)
+	proxy.cache.Set("GET|http://example.com/a", &CachedResponse{StatusCode: 200, Body: []byte("a")})
+	proxy.cache.Set("GET|http://example.com/b", &CachedResponse{StatusCode: 200, Body: []byte("b")})
+	proxy.cache.Get("GET|http://example.com/a")
+
+	w := adminRequest(admin, "GET", "/cache", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var caches map[string]CacheStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &caches))
+	assert.Equal(t, 2, caches["shared"].Size)
+	assert.Equal(t, uint64(1), caches["shared"].Hits)
+	assert.Equal(t, float64(1), caches["shared"].HitRatio)
+
+	w = adminRequest(admin, "POST", "/cache/purge", `{"keys":["GET|http://example.com/a","GET|http://example.com/missing"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"purged":1}`, w.Body.String())
+	assert.Equal(t, 1, proxy.cache.Size())
+
+	w = adminRequest(admin, "POST", "/cache/purge", `{"all":true,"cache":"shared"}`)
+	assert.JSONEq(t, `{"purged":1}`, w.Body.String())
+	assert.Equal(t, 0, proxy.cache.Size())
+
+	w = adminRequest(admin, "POST", "/cache/purge", `{"all":true,"cache":"nope"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdmin_RateLimitAndReset(t *testing.T) {
+	admin, proxy := newTestAdmin(t)
+	proxy.rateLimiter.Allow("10.0.0.1")
+	proxy.rateLimiter.Allow("10.0.0.2")
+
+	w := adminRequest(admin, "GET", "/ratelimit", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var limiters map[string]RateLimiterStatus
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &limiters))
+	assert.Equal(t, 2, limiters["shared"].Buckets)
+	assert.Equal(t, 60, limiters["shared"].RequestsPerMinute)
+
+	w = adminRequest(admin, "POST", "/ratelimit/reset", `{"client":"10.0.0.1"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"reset":1}`, w.Body.String())
+	buckets, _ := proxy.rateLimiter.Stats()
+	assert.Equal(t, 1, buckets)
+}
+
+func TestAdmin_Errors(t *testing.T) {
+	admin, _ := newTestAdmin(t)
+
+	w := adminRequest(admin, "GET", "/cache/purge", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "POST", w.Header().Get("Allow"))
+
+	w = adminRequest(admin, "POST", "/cache/purge", `{"bogus":true}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(admin, "POST", "/ratelimit/reset", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(admin, "GET", "/unknown", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var resp ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "not_found", resp.Error)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
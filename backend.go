@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errNoBackendAvailable is returned when a pool has no backend to send a request to
+var errNoBackendAvailable = errors.New("no backend available")
+
+// Backend represents a single upstream server
+type Backend struct {
+	URL    *url.URL
+	Weight int
+
+	transport   http.RoundTripper
+	activeConns int64
+	unhealthy   atomic.Bool
+	draining    atomic.Bool
+	outlier     *OutlierDetector
+	breaker     *CircuitBreaker
+}
+
+// BackendStatus is a snapshot of a backend's state
+type BackendStatus struct {
+	URL               string `json:"url"`
+	Weight            int    `json:"weight"`
+	Healthy           bool   `json:"healthy"`
+	Ejected           bool   `json:"ejected"`
+	Draining          bool   `json:"draining"`
+	CircuitState      string `json:"circuit_state,omitempty"`
+	ActiveConnections int64  `json:"active_connections"`
+}
+
+// NewBackend creates a backend from its configuration
+func NewBackend(cfg BackendConfig) (*Backend, error) {
+	target, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend URL %q: %v", cfg.URL, err)
+	}
+
+	weight := cfg.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+
+	transport, err := newBackendTransport(cfg.TLS, cfg.Protocol)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %v", cfg.URL, err)
+	}
+
+	return &Backend{
+		URL:       target,
+		Weight:    weight,
+		transport: transport,
+	}, nil
+}
+
+// ActiveConnections returns the number of in-flight requests to the backend
+func (b *Backend) ActiveConnections() int64 {
+	return atomic.LoadInt64(&b.activeConns)
+}
+
+// Healthy reports whether the backend is passing its health checks
+func (b *Backend) Healthy() bool {
+	return !b.unhealthy.Load()
+}
+
+func (b *Backend) setHealthy(healthy bool) {
+	b.unhealthy.Store(!healthy)
+}
+
+// Ejected reports whether passive outlier detection has ejected the backend
+func (b *Backend) Ejected() bool {
+	return b.outlier != nil && b.outlier.Ejected()
+}
+
+// Draining reports whether the backend has been taken out of rotation by an
+// operator
+func (b *Backend) Draining() bool {
+	return b.draining.Load()
+}
+
+// SetDraining takes the backend out of rotation, or puts it back. Requests
+// already in flight to a draining backend are allowed to finish.
+func (b *Backend) SetDraining(draining bool) {
+	b.draining.Store(draining)
+}
+
+// CircuitState returns the state of the backend's circuit breaker
+func (b *Backend) CircuitState() CircuitState {
+	if b.breaker == nil {
+		return CircuitClosed
+	}
+	return b.breaker.State()
+}
+
+// Available reports whether the backend may receive new requests
+func (b *Backend) Available() bool {
+	if !b.Healthy() || b.Draining() {
+		return false
+	}
+	if b.breaker != nil && !b.breaker.Ready() {
+		return false
+	}
+	return b.outlier == nil || b.outlier.Admit()
+}
+
+// Status returns a snapshot of the backend's state
+func (b *Backend) Status() BackendStatus {
+	status := BackendStatus{
+		URL:               b.URL.String(),
+		Weight:            b.Weight,
+		Healthy:           b.Healthy(),
+		Ejected:           b.Ejected(),
+		Draining:          b.Draining(),
+		ActiveConnections: b.ActiveConnections(),
+	}
+	if b.breaker != nil {
+		status.CircuitState = b.breaker.State().String()
+	}
+	return status
+}
+
+// inheritState carries over the operator drain flag, health, circuit
+// breaker and outlier detection state of old, the backend with the same URL
+// in the configuration being replaced. Breakers and detectors are only
+// reused if their settings are unchanged.
+func (b *Backend) inheritState(old *Backend) {
+	b.SetDraining(old.Draining())
+	b.setHealthy(old.Healthy())
+	if b.breaker != nil && old.breaker != nil && b.breaker.config == old.breaker.config {
+		b.breaker = old.breaker
+	}
+	if b.outlier != nil && old.outlier != nil && b.outlier.config == old.outlier.config {
+		b.outlier = old.outlier
+	}
+}
+
+// backendAddress returns the host:port of a backend URL, filling in the
+// default port for its scheme
+func backendAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// BackendPool distributes requests across a set of backends
+type BackendPool struct {
+	backends []*Backend
+	balancer Balancer
+	proxy    *httputil.ReverseProxy
+	retry    *retrier
+}
+
+// NewBackendPool creates a pool for the given backends and load balancing strategy
+func NewBackendPool(configs []BackendConfig, strategy, hashKey string) (*BackendPool, error) {
+	if len(configs) == 0 {
+		return nil, errors.New("no backends configured")
+	}
+
+	pool := &BackendPool{}
+	for _, cfg := range configs {
+		backend, err := NewBackend(cfg)
+		if err != nil {
+			return nil, err
+		}
+		pool.backends = append(pool.backends, backend)
+	}
+
+	balancer, err := NewBalancer(strategy, hashKey, pool.backends)
+	if err != nil {
+		return nil, err
+	}
+	pool.balancer = balancer
+
+	pool.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// Keep appending to the client's X-Forwarded-For chain; the
+			// backend URL is filled in by the pool transport
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetXForwarded()
+		},
+		Transport:    pool,
+		ErrorHandler: pool.handleError,
+	}
+
+	return pool, nil
+}
+
+// EnableOutlierDetection ejects backends of the pool that keep failing live
+// requests. It must be called before the pool starts serving.
+func (p *BackendPool) EnableOutlierDetection(config OutlierConfig) {
+	for _, backend := range p.backends {
+		backend.outlier = NewOutlierDetector(config)
+	}
+}
+
+// EnableCircuitBreakers wraps every backend of the pool in a circuit
+// breaker. It must be called before the pool starts serving.
+func (p *BackendPool) EnableCircuitBreakers(config CircuitBreakerConfig) {
+	for _, backend := range p.backends {
+		backend.breaker = NewCircuitBreaker(backend.URL.Host, config)
+	}
+}
+
+// EnableRetries retries failed idempotent requests on other backends. It
+// must be called before the pool starts serving.
+func (p *BackendPool) EnableRetries(config RetryConfig) {
+	p.retry = newRetrier(config)
+}
+
+// closeIdleConnections closes idle connections of backends with their own
+// transport. The shared default transport is left alone.
+func (p *BackendPool) closeIdleConnections() {
+	for _, backend := range p.backends {
+		if backend.transport != http.DefaultTransport {
+			if t, ok := backend.transport.(interface{ CloseIdleConnections() }); ok {
+				t.CloseIdleConnections()
+			}
+		}
+	}
+}
+
+// Backends returns the backends in the pool
+func (p *BackendPool) Backends() []*Backend {
+	return p.backends
+}
+
+// Status returns a snapshot of every backend in the pool
+func (p *BackendPool) Status() []BackendStatus {
+	statuses := make([]BackendStatus, 0, len(p.backends))
+	for _, backend := range p.backends {
+		statuses = append(statuses, backend.Status())
+	}
+	return statuses
+}
+
+// ServeHTTP proxies the request to one of the pool's backends
+func (p *BackendPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.proxy.ServeHTTP(w, r)
+}
+
+// availableBackends returns the backends currently in rotation, leaving out
+// those already tried for this request unless no other backend is left
+func (p *BackendPool) availableBackends(tried map[*Backend]bool) []*Backend {
+	var available, untried []*Backend
+	for _, backend := range p.backends {
+		if !backend.Available() {
+			continue
+		}
+		available = append(available, backend)
+		if !tried[backend] {
+			untried = append(untried, backend)
+		}
+	}
+
+	if len(untried) > 0 {
+		return untried
+	}
+	return available
+}
+
+// RoundTrip picks a backend for the outgoing request and forwards it there,
+// retrying on another backend when retries are enabled and allowed
+func (p *BackendPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	if p.retry == nil {
+		backend := p.balancer.Pick(req, p.availableBackends(nil))
+		if backend == nil {
+			return nil, p.unavailableError()
+		}
+		return backend.roundTrip(req)
+	}
+
+	p.retry.budget.RecordRequest()
+
+	replayable := isIdempotent(req) && p.retry.config.MaxAttempts > 1
+	if replayable {
+		var err error
+		if replayable, err = bufferRequestBody(req, p.retry.config.MaxBodyBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	tried := make(map[*Backend]bool)
+	for attempt := 1; ; attempt++ {
+		backend := p.balancer.Pick(req, p.availableBackends(tried))
+		if backend == nil {
+			return nil, p.unavailableError()
+		}
+		tried[backend] = true
+
+		if attempt > 1 && req.GetBody != nil {
+			req.Body, _ = req.GetBody()
+		}
+
+		resp, err := backend.roundTrip(req)
+		if !replayable || attempt >= p.retry.config.MaxAttempts ||
+			!p.retry.shouldRetry(req, resp, err) || !p.retry.budget.TryRetry() {
+			return resp, err
+		}
+
+		attrs := append(requestAttrs(req), "attempt", attempt, "max_attempts", p.retry.config.MaxAttempts)
+		if err != nil {
+			slog.Warn("retrying request", append(attrs, "error", err)...)
+		} else {
+			slog.Warn("retrying request", append(attrs, "status", resp.StatusCode)...)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		if err := p.retry.wait(req.Context(), attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// unavailableError explains why no backend could be picked, reporting an
+// open circuit when that is what keeps backends out of rotation
+func (p *BackendPool) unavailableError() error {
+	for _, backend := range p.backends {
+		if backend.Healthy() && !backend.Ejected() && backend.CircuitState() != CircuitClosed {
+			return errCircuitOpen
+		}
+	}
+	return errNoBackendAvailable
+}
+
+// roundTrip sends the request to this backend, tracking it as an active
+// connection until the response body is closed
+func (b *Backend) roundTrip(req *http.Request) (*http.Response, error) {
+	info := getRequestInfo(req.Context())
+	info.setBackend(b.URL.Host)
+
+	ctx, span := startSpan(req.Context(), "upstream "+req.Method, SpanKindClient)
+	defer span.End()
+	span.SetAttribute("server.address", b.URL.Host)
+
+	if b.breaker != nil {
+		if err := b.breaker.Allow(); err != nil {
+			info.addUpstreamError(b.URL.Host, upstreamErrorReason(nil, err))
+			span.SetError(err.Error())
+			return nil, err
+		}
+	}
+
+	outreq := req.Clone(ctx)
+	rewriteRequestURL(outreq, b.URL)
+	injectTraceContext(outreq.Header, span)
+
+	atomic.AddInt64(&b.activeConns, 1)
+	release := func() { atomic.AddInt64(&b.activeConns, -1) }
+
+	start := time.Now()
+	resp, err := b.transport.RoundTrip(outreq)
+	info.setUpstreamLatency(time.Since(start))
+	b.observe(resp, err)
+	if isUpstreamFailure(resp, err) {
+		info.addUpstreamError(b.URL.Host, upstreamErrorReason(resp, err))
+	}
+	if err != nil {
+		span.SetError(err.Error())
+		release()
+		return nil, err
+	}
+
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetError(fmt.Sprintf("status %d", resp.StatusCode))
+	}
+	resp.Body = newTrackedBody(resp.Body, release)
+	return resp, nil
+}
+
+// observe feeds the outcome of a live request to the circuit breaker and
+// outlier detector
+func (b *Backend) observe(resp *http.Response, err error) {
+	failed := isUpstreamFailure(resp, err)
+
+	if b.breaker != nil {
+		if errors.Is(err, context.Canceled) {
+			b.breaker.Ignore()
+		} else {
+			b.breaker.Record(!failed)
+		}
+	}
+
+	if b.outlier == nil {
+		return
+	}
+
+	if ejected, duration := b.outlier.Record(!failed); ejected {
+		slog.Warn("ejecting outlier backend", "backend", b.URL.Host, "ejection", duration,
+			"consecutive_failures", b.outlier.config.ConsecutiveFailures)
+	}
+}
+
+// upstreamErrorReason classifies a failed round trip for metrics
+func upstreamErrorReason(resp *http.Response, err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "http_" + strconv.Itoa(resp.StatusCode)
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "connection_error"
+	}
+}
+
+// handleError converts proxy errors into structured error responses
+func (p *BackendPool) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "circuit_open", "The backend is temporarily unavailable")
+	case errors.Is(err, errNoBackendAvailable):
+		writeErrorResponse(w, r, http.StatusServiceUnavailable, "no_backend_available", "No backend is available to handle the request")
+	case errors.Is(err, context.DeadlineExceeded):
+		writeErrorResponse(w, r, http.StatusGatewayTimeout, "gateway_timeout", "Request timed out")
+	default:
+		slog.Error("proxy error", append(requestAttrs(r), "error", err)...)
+		writeErrorResponse(w, r, http.StatusBadGateway, "bad_gateway", "The backend could not be reached")
+	}
+}
+
+// trackedBody runs a callback once when the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+// trackedReadWriteBody preserves io.Writer on bodies of upgraded (101)
+// responses, which httputil.ReverseProxy requires to tunnel the connection
+type trackedReadWriteBody struct {
+	*trackedBody
+	io.Writer
+}
+
+func newTrackedBody(body io.ReadCloser, done func()) io.ReadCloser {
+	tracked := &trackedBody{ReadCloser: body, done: done}
+	if rw, ok := body.(io.ReadWriteCloser); ok {
+		return &trackedReadWriteBody{trackedBody: tracked, Writer: rw}
+	}
+	return tracked
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// rewriteRequestURL points the request at the target, joining the target's
+// base path with the request path the same way NewSingleHostReverseProxy does
+func rewriteRequestURL(req *http.Request, target *url.URL) {
+	targetQuery := target.RawQuery
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path, req.URL.RawPath = joinURLPath(target, req.URL)
+	if targetQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = targetQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
+	}
+}
+
+func joinURLPath(a, b *url.URL) (path, rawpath string) {
+	if a.RawPath == "" && b.RawPath == "" {
+		return singleJoiningSlash(a.Path, b.Path), ""
+	}
+
+	apath := a.EscapedPath()
+	bpath := b.EscapedPath()
+
+	aslash := strings.HasSuffix(apath, "/")
+	bslash := strings.HasPrefix(bpath, "/")
+
+	switch {
+	case aslash && bslash:
+		return a.Path + b.Path[1:], apath + bpath[1:]
+	case !aslash && !bslash:
+		return a.Path + "/" + b.Path, apath + "/" + bpath
+	}
+	return a.Path + b.Path, apath + bpath
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
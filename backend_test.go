@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackend_DefaultWeight(t *testing.T) {
+	backend, err := NewBackend(BackendConfig{URL: "http://example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, backend.Weight)
+	assert.Equal(t, "example.com", backend.URL.Host)
+}
+
+func TestNewBackend_InvalidURL(t *testing.T) {
+	_, err := NewBackend(BackendConfig{URL: "http://[::1"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid backend URL")
+}
+
+func TestNewBackendPool_NoBackends(t *testing.T) {
+	_, err := NewBackendPool(nil, StrategyRoundRobin, "")
+	assert.Error(t, err)
+}
+
+func TestBackendPool_DistributesRequests(t *testing.T) {
+	hits := make(map[string]int)
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[name]++
+			w.Write([]byte(name))
+		}))
+	}
+	backendA := newServer("a")
+	defer backendA.Close()
+	backendB := newServer("b")
+	defer backendB.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: backendA.URL}, {URL: backendB.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Equal(t, 2, hits["a"])
+	assert.Equal(t, 2, hits["b"])
+}
+
+func TestBackendPool_PreservesPathQueryAndHost(t *testing.T) {
+	var gotPath, gotQuery, gotHost, gotForwardedFor string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		gotHost = r.Host
+		gotForwardedFor = r.Header.Get("X-Forwarded-For")
+	}))
+	defer backend.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: backend.URL + "/base?fixed=1"}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "http://proxy.example.com/users?id=7", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	pool.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "/base/users", gotPath)
+	assert.Equal(t, "fixed=1&id=7", gotQuery)
+	assert.Equal(t, "proxy.example.com", gotHost)
+	assert.Equal(t, "198.51.100.1, 192.0.2.10", gotForwardedFor)
+}
+
+func TestBackendPool_ReleasesActiveConnections(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: backend.URL}}, StrategyLeastConnections, "")
+	assert.NoError(t, err)
+
+	pool.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, int64(0), pool.Backends()[0].ActiveConnections())
+}
+
+func TestBackendPool_UnreachableBackendReturnsErrorResponse(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: "http://127.0.0.1:0"}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest("GET", "/down", nil))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var errResp ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "bad_gateway", errResp.Error)
+	assert.Equal(t, http.StatusBadGateway, errResp.Code)
+}
+
+func TestBackend_Draining(t *testing.T) {
+	backend, err := NewBackend(BackendConfig{URL: "http://backend:8080"})
+	assert.NoError(t, err)
+	assert.True(t, backend.Available())
+
+	backend.SetDraining(true)
+	assert.False(t, backend.Available())
+	assert.True(t, backend.Status().Draining)
+
+	backend.SetDraining(false)
+	assert.True(t, backend.Available())
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Load balancing strategies accepted in the load_balancer setting
+const (
+	StrategyRoundRobin         = "round_robin"
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+	StrategyLeastConnections   = "least_connections"
+	StrategyRandomTwoChoices   = "random_two_choices"
+	StrategyConsistentHash     = "consistent_hash"
+)
+
+// hashRingReplicas is the number of virtual nodes per unit of backend weight
+const hashRingReplicas = 100
+
+// Balancer selects a backend for a request from a set of candidates
+type Balancer interface {
+	// Pick returns one of the candidates, or nil if there are none
+	Pick(r *http.Request, candidates []*Backend) *Backend
+}
+
+// NewBalancer creates a balancer for the given strategy. The backends are the
+// full pool, used by strategies that precompute state such as a hash ring.
+func NewBalancer(strategy, hashKey string, backends []*Backend) (Balancer, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return &roundRobinBalancer{}, nil
+	case StrategyWeightedRoundRobin:
+		return &weightedRoundRobinBalancer{current: make(map[*Backend]int)}, nil
+	case StrategyLeastConnections:
+		return &leastConnectionsBalancer{}, nil
+	case StrategyRandomTwoChoices:
+		return &randomTwoChoicesBalancer{}, nil
+	case StrategyConsistentHash:
+		keyFunc, err := parseHashKey(hashKey)
+		if err != nil {
+			return nil, err
+		}
+		return newConsistentHashBalancer(backends, keyFunc), nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+}
+
+// parseHashKey builds a function extracting the consistent hashing key from a
+// request. Supported forms are "client_ip", "header:<name>" and "cookie:<name>".
+func parseHashKey(spec string) (func(*http.Request) string, error) {
+	if spec == "" || spec == "client_ip" {
+		return getClientKey, nil
+	}
+
+	kind, name, ok := strings.Cut(spec, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid hash key %q", spec)
+	}
+
+	switch kind {
+	case "header":
+		return func(r *http.Request) string {
+			return r.Header.Get(name)
+		}, nil
+	case "cookie":
+		return func(r *http.Request) string {
+			if cookie, err := r.Cookie(name); err == nil {
+				return cookie.Value
+			}
+			return ""
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid hash key %q", spec)
+	}
+}
+
+// roundRobinBalancer cycles through the candidates in order
+type roundRobinBalancer struct {
+	counter uint64
+}
+
+func (b *roundRobinBalancer) Pick(r *http.Request, candidates []*Backend) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&b.counter, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weightedRoundRobinBalancer implements smooth weighted round-robin, which
+// interleaves backends in proportion to their weights instead of sending
+// bursts to the heaviest one
+type weightedRoundRobinBalancer struct {
+	current map[*Backend]int
+	mu      sync.Mutex
+}
+
+func (b *weightedRoundRobinBalancer) Pick(r *http.Request, candidates []*Backend) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *Backend
+	total := 0
+	for _, backend := range candidates {
+		b.current[backend] += backend.Weight
+		total += backend.Weight
+		if best == nil || b.current[backend] > b.current[best] {
+			best = backend
+		}
+	}
+	b.current[best] -= total
+
+	return best
+}
+
+// leastConnectionsBalancer picks the candidate with the fewest in-flight
+// requests, rotating the starting point so ties are spread evenly
+type leastConnectionsBalancer struct {
+	counter uint64
+}
+
+func (b *leastConnectionsBalancer) Pick(r *http.Request, candidates []*Backend) *Backend {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	start := int((atomic.AddUint64(&b.counter, 1) - 1) % uint64(len(candidates)))
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		backend := candidates[(start+i)%len(candidates)]
+		if backend.ActiveConnections() < best.ActiveConnections() {
+			best = backend
+		}
+	}
+
+	return best
+}
+
+// randomTwoChoicesBalancer samples two random candidates and picks the less
+// loaded one ("power of two choices")
+type randomTwoChoicesBalancer struct{}
+
+func (b *randomTwoChoicesBalancer) Pick(r *http.Request, candidates []*Backend) *Backend {
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	first, second := candidates[i], candidates[j]
+	if second.ActiveConnections() < first.ActiveConnections() {
+		return second
+	}
+	return first
+}
+
+// consistentHashBalancer maps request keys onto a hash ring of backends so
+// the same key keeps reaching the same backend while the pool is stable
+type consistentHashBalancer struct {
+	ring    []uint32
+	owners  map[uint32]*Backend
+	keyFunc func(*http.Request) string
+}
+
+func newConsistentHashBalancer(backends []*Backend, keyFunc func(*http.Request) string) *consistentHashBalancer {
+	b := &consistentHashBalancer{
+		owners:  make(map[uint32]*Backend),
+		keyFunc: keyFunc,
+	}
+
+	for _, backend := range backends {
+		for i := 0; i < hashRingReplicas*backend.Weight; i++ {
+			h := hashKey(backend.URL.String() + "#" + strconv.Itoa(i))
+			if _, taken := b.owners[h]; taken {
+				continue
+			}
+			b.owners[h] = backend
+			b.ring = append(b.ring, h)
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i] < b.ring[j] })
+
+	return b
+}
+
+func (b *consistentHashBalancer) Pick(r *http.Request, candidates []*Backend) *Backend {
+	if len(candidates) == 0 || len(b.ring) == 0 {
+		return nil
+	}
+
+	key := b.keyFunc(r)
+	if key == "" {
+		// Fall back to the client address when the key is missing
+		key = getClientKey(r)
+	}
+
+	allowed := make(map[*Backend]bool, len(candidates))
+	for _, backend := range candidates {
+		allowed[backend] = true
+	}
+
+	// Walk clockwise from the key's position to the first allowed backend
+	h := hashKey(key)
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i] >= h })
+	for i := 0; i < len(b.ring); i++ {
+		backend := b.owners[b.ring[(start+i)%len(b.ring)]]
+		if allowed[backend] {
+			return backend
+		}
+	}
+
+	return nil
+}
+
+// hashKey returns the FNV-1a hash of a string
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
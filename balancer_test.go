@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBackends(t *testing.T, weights ...int) []*Backend {
+	var backends []*Backend
+	for i, weight := range weights {
+		backend, err := NewBackend(BackendConfig{URL: "http://backend-" + string(rune('a'+i)) + ".test", Weight: weight})
+		assert.NoError(t, err)
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
+func TestNewBalancer_UnknownStrategy(t *testing.T) {
+	_, err := NewBalancer("fastest", "", nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown load balancing strategy")
+}
+
+func TestNewBalancer_InvalidHashKey(t *testing.T) {
+	_, err := NewBalancer(StrategyConsistentHash, "query:id", nil)
+	assert.Error(t, err)
+}
+
+func TestBalancer_NoCandidates(t *testing.T) {
+	backends := newTestBackends(t, 1, 1)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	for _, strategy := range []string{StrategyRoundRobin, StrategyWeightedRoundRobin, StrategyLeastConnections, StrategyRandomTwoChoices, StrategyConsistentHash} {
+		balancer, err := NewBalancer(strategy, "client_ip", backends)
+		assert.NoError(t, err)
+		assert.Nil(t, balancer.Pick(req, nil), "strategy %s should return nil without candidates", strategy)
+	}
+}
+
+func TestRoundRobinBalancer_Cycles(t *testing.T) {
+	backends := newTestBackends(t, 1, 1, 1)
+	balancer, _ := NewBalancer(StrategyRoundRobin, "", backends)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, backends[i%3], balancer.Pick(req, backends))
+	}
+}
+
+func TestWeightedRoundRobinBalancer_Proportions(t *testing.T) {
+	backends := newTestBackends(t, 5, 1, 1)
+	balancer, _ := NewBalancer(StrategyWeightedRoundRobin, "", backends)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	counts := make(map[*Backend]int)
+	for i := 0; i < 70; i++ {
+		counts[balancer.Pick(req, backends)]++
+	}
+
+	assert.Equal(t, 50, counts[backends[0]])
+	assert.Equal(t, 10, counts[backends[1]])
+	assert.Equal(t, 10, counts[backends[2]])
+}
+
+func TestWeightedRoundRobinBalancer_Smooth(t *testing.T) {
+	backends := newTestBackends(t, 2, 1)
+	balancer, _ := NewBalancer(StrategyWeightedRoundRobin, "", backends)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	// Smooth WRR interleaves rather than sending consecutive bursts
+	sequence := []*Backend{}
+	for i := 0; i < 3; i++ {
+		sequence = append(sequence, balancer.Pick(req, backends))
+	}
+	assert.Equal(t, []*Backend{backends[0], backends[1], backends[0]}, sequence)
+}
+
+func TestLeastConnectionsBalancer_PicksLeastLoaded(t *testing.T) {
+	backends := newTestBackends(t, 1, 1, 1)
+	atomic.StoreInt64(&backends[0].activeConns, 5)
+	atomic.StoreInt64(&backends[1].activeConns, 1)
+	atomic.StoreInt64(&backends[2].activeConns, 3)
+
+	balancer, _ := NewBalancer(StrategyLeastConnections, "", backends)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, backends[1], balancer.Pick(req, backends))
+	}
+}
+
+func TestRandomTwoChoicesBalancer_AvoidsMostLoaded(t *testing.T) {
+	backends := newTestBackends(t, 1, 1)
+	atomic.StoreInt64(&backends[0].activeConns, 10)
+
+	balancer, _ := NewBalancer(StrategyRandomTwoChoices, "", backends)
+	req := httptest.NewRequest("GET", "/", nil)
+
+	// With two candidates both are always sampled, so the idle one wins
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, backends[1], balancer.Pick(req, backends))
+	}
+	assert.Equal(t, backends[0], balancer.Pick(req, backends[:1]))
+}
+
+func TestConsistentHashBalancer_StickyByClientIP(t *testing.T) {
+	backends := newTestBackends(t, 1, 1, 1)
+	balancer, _ := NewBalancer(StrategyConsistentHash, "client_ip", backends)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+
+	first := balancer.Pick(req, backends)
+	assert.NotNil(t, first)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, balancer.Pick(req, backends))
+	}
+}
+
+func TestConsistentHashBalancer_HeaderAndCookieKeys(t *testing.T) {
+	backends := newTestBackends(t, 1, 1, 1, 1)
+
+	headerBalancer, err := NewBalancer(StrategyConsistentHash, "header:X-User-ID", backends)
+	assert.NoError(t, err)
+	cookieBalancer, err := NewBalancer(StrategyConsistentHash, "cookie:session", backends)
+	assert.NoError(t, err)
+
+	// Different clients with the same key land on the same backend
+	req1 := httptest.NewRequest("GET", "/", nil)
+	req1.RemoteAddr = "10.0.0.1:1000"
+	req1.Header.Set("X-User-ID", "user-42")
+	req1.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	req2 := httptest.NewRequest("GET", "/", nil)
+	req2.RemoteAddr = "10.0.0.2:2000"
+	req2.Header.Set("X-User-ID", "user-42")
+	req2.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+
+	assert.Equal(t, headerBalancer.Pick(req1, backends), headerBalancer.Pick(req2, backends))
+	assert.Equal(t, cookieBalancer.Pick(req1, backends), cookieBalancer.Pick(req2, backends))
+}
+
+func TestConsistentHashBalancer_SkipsUnavailableBackend(t *testing.T) {
+	backends := newTestBackends(t, 1, 1, 1)
+	balancer, _ := NewBalancer(StrategyConsistentHash, "header:X-User-ID", backends)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-User-ID", "user-7")
+
+	owner := balancer.Pick(req, backends)
+	var remaining []*Backend
+	for _, backend := range backends {
+		if backend != owner {
+			remaining = append(remaining, backend)
+		}
+	}
+
+	fallback := balancer.Pick(req, remaining)
+	assert.NotNil(t, fallback)
+	assert.NotEqual(t, owner, fallback)
+	// The fallback is stable as well
+	assert.Equal(t, fallback, balancer.Pick(req, remaining))
+}
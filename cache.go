@@ -3,6 +3,7 @@ package main
 import (
 	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,6 +29,36 @@ type Cache struct {
 	items    map[string]*list.Element
 	lru      *list.List
 	mutex    sync.RWMutex
+
+	// Counters for sizing the cache
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	expirations atomic.Uint64
+	evictions   atomic.Uint64
+	sets        atomic.Uint64
+	bytes       atomic.Int64
+}
+
+// CacheStats is a snapshot of a cache's size and counters
+type CacheStats struct {
+	Size        int    `json:"size"`
+	Capacity    int    `json:"capacity"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Expirations uint64 `json:"expirations"`
+	Evictions   uint64 `json:"evictions"`
+	Sets        uint64 `json:"sets"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// HitRatio returns the fraction of lookups served from the cache, or zero
+// if there have been no lookups
+func (s CacheStats) HitRatio() float64 {
+	lookups := s.Hits + s.Misses
+	if lookups == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(lookups)
 }
 
 // NewCache creates a new LRU cache
@@ -42,8 +73,9 @@ func NewCache(capacity int, ttlSeconds int) *Cache {
 
 // Get retrieves a cached response if it exists and hasn't expired
 func (c *Cache) Get(key string) (*CachedResponse, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+	// A write lock is needed since hits reorder the LRU list
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
 	if elem, exists := c.items[key]; exists {
 		entry := elem.Value.(*CacheEntry)
@@ -51,19 +83,19 @@ func (c *Cache) Get(key string) (*CachedResponse, bool) {
 		// Check if expired
 		if time.Now().After(entry.Expiry) {
 			// Remove expired entry
-			c.mutex.RUnlock()
-			c.mutex.Lock()
 			c.removeElement(elem)
-			c.mutex.Unlock()
-			c.mutex.RLock()
+			c.expirations.Add(1)
+			c.misses.Add(1)
 			return nil, false
 		}
 
 		// Move to front (most recently used)
 		c.lru.MoveToFront(elem)
+		c.hits.Add(1)
 		return entry.Response, true
 	}
 
+	c.misses.Add(1)
 	return nil, false
 }
 
@@ -72,10 +104,13 @@ func (c *Cache) Set(key string, response *CachedResponse) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	c.sets.Add(1)
+
 	// Check if key already exists
 	if elem, exists := c.items[key]; exists {
 		// Update existing entry
 		entry := elem.Value.(*CacheEntry)
+		c.bytes.Add(int64(len(response.Body) - len(entry.Response.Body)))
 		entry.Response = response
 		entry.Expiry = time.Now().Add(c.ttl)
 		c.lru.MoveToFront(elem)
@@ -91,6 +126,7 @@ func (c *Cache) Set(key string, response *CachedResponse) {
 
 	elem := c.lru.PushFront(entry)
 	c.items[key] = elem
+	c.bytes.Add(int64(len(response.Body)))
 
 	// Evict if over capacity
 	if c.lru.Len() > c.capacity {
@@ -102,9 +138,8 @@ func (c *Cache) Set(key string, response *CachedResponse) {
 func (c *Cache) evict() {
 	elem := c.lru.Back()
 	if elem != nil {
-		entry := elem.Value.(*CacheEntry)
-		delete(c.items, entry.Key)
-		c.lru.Remove(elem)
+		c.removeElement(elem)
+		c.evictions.Add(1)
 	}
 }
 
@@ -113,6 +148,19 @@ func (c *Cache) removeElement(elem *list.Element) {
 	entry := elem.Value.(*CacheEntry)
 	delete(c.items, entry.Key)
 	c.lru.Remove(elem)
+	c.bytes.Add(-int64(len(entry.Response.Body)))
+}
+
+// Delete removes a key from the cache, reporting whether it was present
+func (c *Cache) Delete(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, exists := c.items[key]
+	if exists {
+		c.removeElement(elem)
+	}
+	return exists
 }
 
 // Clear removes all items from the cache
@@ -122,6 +170,7 @@ func (c *Cache) Clear() {
 
 	c.items = make(map[string]*list.Element)
 	c.lru = list.New()
+	c.bytes.Store(0)
 }
 
 // Size returns the current number of items in cache
@@ -132,8 +181,19 @@ func (c *Cache) Size() int {
 }
 
 // Stats returns cache statistics
-func (c *Cache) Stats() (size int, capacity int) {
+func (c *Cache) Stats() CacheStats {
 	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	return c.lru.Len(), c.capacity
+	size := c.lru.Len()
+	c.mutex.RUnlock()
+
+	return CacheStats{
+		Size:        size,
+		Capacity:    c.capacity,
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Expirations: c.expirations.Load(),
+		Evictions:   c.evictions.Load(),
+		Sets:        c.sets.Load(),
+		Bytes:       c.bytes.Load(),
+	}
 }
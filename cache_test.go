@@ -174,9 +174,11 @@ func TestCache_Stats(t *testing.T) {
 	cache.Set("key1", &CachedResponse{StatusCode: 200, Body: []byte("data1")})
 	cache.Set("key2", &CachedResponse{StatusCode: 200, Body: []byte("data2")})
 
-	size, capacity := cache.Stats()
-	assert.Equal(t, 2, size)
-	assert.Equal(t, 5, capacity)
+	stats := cache.Stats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, 5, stats.Capacity)
+	assert.Equal(t, uint64(2), stats.Sets)
+	assert.Equal(t, int64(10), stats.Bytes)
 }
 
 func TestCache_ConcurrentAccess(t *testing.T) {
@@ -222,3 +224,60 @@ func TestCache_Size(t *testing.T) {
 	cache.Set("key2", &CachedResponse{StatusCode: 200, Body: []byte("data2")})
 	assert.Equal(t, 2, cache.Size())
 }
+
+func TestCache_Counters(t *testing.T) {
+	cache := NewCache(2, 60)
+	cache.Set("key1", &CachedResponse{StatusCode: 200, Body: []byte("aaaa")})
+	cache.Set("key2", &CachedResponse{StatusCode: 200, Body: []byte("bb")})
+
+	cache.Get("key1")
+	cache.Get("key1")
+	cache.Get("missing")
+
+	// key2 is least recently used and gets evicted
+	cache.Set("key3", &CachedResponse{StatusCode: 200, Body: []byte("c")})
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, uint64(3), stats.Sets)
+	assert.Equal(t, int64(5), stats.Bytes)
+	assert.InDelta(t, 2.0/3.0, stats.HitRatio(), 0.0001)
+
+	// Replacing an entry adjusts the stored bytes
+	cache.Set("key1", &CachedResponse{StatusCode: 200, Body: []byte("a")})
+	assert.Equal(t, int64(2), cache.Stats().Bytes)
+
+	cache.Clear()
+	assert.Equal(t, int64(0), cache.Stats().Bytes)
+}
+
+func TestCache_ExpirationCounted(t *testing.T) {
+	cache := NewCache(2, 0)
+	cache.Set("key1", &CachedResponse{StatusCode: 200, Body: []byte("data")})
+	time.Sleep(time.Millisecond)
+
+	_, found := cache.Get("key1")
+	assert.False(t, found)
+
+	stats := cache.Stats()
+	assert.Equal(t, uint64(1), stats.Expirations)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, int64(0), stats.Bytes)
+	assert.Equal(t, 0, stats.Size)
+}
+
+func TestCacheStats_HitRatioWithoutLookups(t *testing.T) {
+	assert.Equal(t, float64(0), NewCache(1, 60).Stats().HitRatio())
+}
+
+func TestCache_Delete(t *testing.T) {
+	cache := NewCache(5, 60)
+	cache.Set("key1", &CachedResponse{StatusCode: 200, Body: []byte("data")})
+
+	assert.True(t, cache.Delete("key1"))
+	assert.False(t, cache.Delete("key1"))
+	assert.Equal(t, 0, cache.Size())
+	assert.Equal(t, int64(0), cache.Stats().Bytes)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned when a backend's circuit breaker rejects a request
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreakerBuckets is the number of buckets in the rolling window
+const circuitBreakerBuckets = 10
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+// Circuit breaker states
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreakerConfig holds the settings for per-backend circuit breakers
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int           // failures in a row that open the circuit, 0 disables
+	ErrorRatePercent    int           // error rate within the window that opens the circuit, 0 disables
+	MinRequests         int           // requests needed in the window before the error rate applies
+	Window              time.Duration // length of the rolling window
+	OpenTimeout         time.Duration // time spent open before trial requests are allowed
+	HalfOpenRequests    int           // trial requests allowed while half-open
+}
+
+// breakerBucket counts requests over one slice of the rolling window
+type breakerBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+// CircuitBreaker stops sending requests to a failing backend. It opens when
+// too many requests fail within a rolling window, rejects requests while
+// open, and after a cool-down lets a limited number of trial requests
+// through (half-open) to decide whether to close again.
+type CircuitBreaker struct {
+	name                string
+	config              CircuitBreakerConfig
+	state               CircuitState
+	buckets             [circuitBreakerBuckets]breakerBucket
+	consecutiveFailures int
+	openedAt            time.Time
+	halfOpenAdmitted    int
+	halfOpenSuccesses   int
+	mu                  sync.Mutex
+	now                 func() time.Time
+}
+
+// NewCircuitBreaker creates a closed circuit breaker
+func NewCircuitBreaker(name string, config CircuitBreakerConfig) *CircuitBreaker {
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+
+	return &CircuitBreaker{
+		name:   name,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.checkOpenTimeout(cb.now())
+	return cb.state
+}
+
+// Ready reports whether the breaker would currently let a request through
+func (cb *CircuitBreaker) Ready() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.checkOpenTimeout(cb.now())
+	switch cb.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return cb.halfOpenAdmitted < cb.config.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow admits a request, returning errCircuitOpen if it must fail fast.
+// Every admitted request must be followed by Record or Ignore.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.checkOpenTimeout(cb.now())
+	switch cb.state {
+	case CircuitOpen:
+		return errCircuitOpen
+	case CircuitHalfOpen:
+		if cb.halfOpenAdmitted >= cb.config.HalfOpenRequests {
+			return errCircuitOpen
+		}
+		cb.halfOpenAdmitted++
+	}
+
+	return nil
+}
+
+// Record registers the outcome of an admitted request
+func (cb *CircuitBreaker) Record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := cb.now()
+	switch cb.state {
+	case CircuitOpen:
+		// Late results from requests admitted before the circuit opened
+		return
+
+	case CircuitHalfOpen:
+		if !success {
+			cb.setState(CircuitOpen, now)
+			return
+		}
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.config.HalfOpenRequests {
+			cb.setState(CircuitClosed, now)
+		}
+		return
+	}
+
+	bucket := cb.bucket(now)
+	bucket.requests++
+	if success {
+		cb.consecutiveFailures = 0
+		return
+	}
+	bucket.failures++
+	cb.consecutiveFailures++
+
+	if cb.shouldOpen(now) {
+		cb.setState(CircuitOpen, now)
+	}
+}
+
+// Ignore releases an admitted request without counting its outcome, such
+// as when the client cancelled it
+func (cb *CircuitBreaker) Ignore() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen && cb.halfOpenAdmitted > 0 {
+		cb.halfOpenAdmitted--
+	}
+}
+
+// shouldOpen checks the consecutive failure and error rate thresholds
+func (cb *CircuitBreaker) shouldOpen(now time.Time) bool {
+	if cb.config.ConsecutiveFailures > 0 && cb.consecutiveFailures >= cb.config.ConsecutiveFailures {
+		return true
+	}
+
+	if cb.config.ErrorRatePercent <= 0 {
+		return false
+	}
+
+	requests, failures := cb.windowTotals(now)
+	if requests == 0 || requests < cb.config.MinRequests {
+		return false
+	}
+	return failures*100 >= cb.config.ErrorRatePercent*requests
+}
+
+// checkOpenTimeout moves an open breaker to half-open once the cool-down has passed
+func (cb *CircuitBreaker) checkOpenTimeout(now time.Time) {
+	if cb.state == CircuitOpen && !now.Before(cb.openedAt.Add(cb.config.OpenTimeout)) {
+		cb.setState(CircuitHalfOpen, now)
+	}
+}
+
+// setState switches state, resetting the counters that belong to it
+func (cb *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	if cb.state == state {
+		return
+	}
+
+	slog.Warn("circuit breaker state changed", "backend", cb.name, "from", cb.state, "to", state)
+	cb.state = state
+
+	switch state {
+	case CircuitOpen:
+		cb.openedAt = now
+	case CircuitHalfOpen:
+		cb.halfOpenAdmitted = 0
+		cb.halfOpenSuccesses = 0
+	case CircuitClosed:
+		cb.consecutiveFailures = 0
+		cb.buckets = [circuitBreakerBuckets]breakerBucket{}
+	}
+}
+
+// bucket returns the window bucket for the current time, resetting it if stale
+func (cb *CircuitBreaker) bucket(now time.Time) *breakerBucket {
+	width := cb.config.Window / circuitBreakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+
+	start := now.Truncate(width)
+	bucket := &cb.buckets[(start.UnixNano()/int64(width))%circuitBreakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// windowTotals sums the buckets that fall within the rolling window
+func (cb *CircuitBreaker) windowTotals(now time.Time) (requests, failures int) {
+	for _, bucket := range cb.buckets {
+		if !bucket.start.IsZero() && now.Sub(bucket.start) < cb.config.Window {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return requests, failures
+}
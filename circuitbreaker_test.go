@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCircuitBreaker(clock *fakeClock, config CircuitBreakerConfig) *CircuitBreaker {
+	cb := NewCircuitBreaker("test", config)
+	cb.now = clock.Now
+	return cb
+}
+
+func TestCircuitBreaker_OpensOnConsecutiveFailures(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	clock := &fakeClock{now: time.Now()}
+	cb := newTestCircuitBreaker(clock, CircuitBreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, cb.Allow())
+		cb.Record(false)
+	}
+
+	assert.Equal(t, CircuitOpen, cb.State())
+	assert.ErrorIs(t, cb.Allow(), errCircuitOpen)
+	assert.False(t, cb.Ready())
+	assert.Contains(t, helper.GetLogs(), "from=closed to=open")
+}
+
+func TestCircuitBreaker_OpensOnErrorRate(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	clock := &fakeClock{now: time.Now()}
+	cb := newTestCircuitBreaker(clock, CircuitBreakerConfig{
+		ErrorRatePercent: 50,
+		MinRequests:      10,
+		Window:           10 * time.Second,
+		OpenTimeout:      time.Minute,
+	})
+
+	// Alternating results never trip a consecutive threshold, but reach 50%
+	for i := 0; i < 9; i++ {
+		cb.Record(i%2 == 0)
+	}
+	assert.Equal(t, CircuitClosed, cb.State(), "below minimum request count")
+
+	cb.Record(false)
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreaker_WindowExpiresOldResults(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	cb := newTestCircuitBreaker(clock, CircuitBreakerConfig{
+		ErrorRatePercent: 50,
+		MinRequests:      4,
+		Window:           10 * time.Second,
+	})
+
+	cb.Record(false)
+	cb.Record(false)
+	cb.Record(true)
+	clock.Advance(11 * time.Second)
+
+	// The old failures have left the window
+	cb.Record(true)
+	cb.Record(true)
+	cb.Record(true)
+	cb.Record(false)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestCircuitBreaker_HalfOpenTrials(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	clock := &fakeClock{now: time.Now()}
+	cb := newTestCircuitBreaker(clock, CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         30 * time.Second,
+		HalfOpenRequests:    2,
+	})
+
+	cb.Record(false)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+
+	// Only the configured number of trial requests are let through
+	assert.NoError(t, cb.Allow())
+	assert.NoError(t, cb.Allow())
+	assert.ErrorIs(t, cb.Allow(), errCircuitOpen)
+
+	cb.Record(true)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	cb.Record(true)
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.Contains(t, helper.GetLogs(), "from=half_open to=closed")
+}
+
+func TestCircuitBreaker_HalfOpenFailureReopens(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	clock := &fakeClock{now: time.Now()}
+	cb := newTestCircuitBreaker(clock, CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: 30 * time.Second})
+
+	cb.Record(false)
+	clock.Advance(30 * time.Second)
+	assert.NoError(t, cb.Allow())
+	cb.Record(false)
+
+	assert.Equal(t, CircuitOpen, cb.State())
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, CircuitOpen, cb.State(), "cool-down restarts after reopening")
+}
+
+func TestCircuitBreaker_IgnoreReleasesTrialSlot(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	clock := &fakeClock{now: time.Now()}
+	cb := newTestCircuitBreaker(clock, CircuitBreakerConfig{ConsecutiveFailures: 1, HalfOpenRequests: 1})
+
+	cb.Record(false)
+	assert.NoError(t, cb.Allow())
+	assert.ErrorIs(t, cb.Allow(), errCircuitOpen)
+
+	cb.Ignore()
+	assert.NoError(t, cb.Allow())
+}
+
+func TestBackendPool_CircuitOpenFailsFast(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	requests := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer backend.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: backend.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	pool.EnableCircuitBreakers(CircuitBreakerConfig{ConsecutiveFailures: 2, OpenTimeout: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		pool.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	assert.Equal(t, "open", pool.Status()[0].CircuitState)
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, 2, requests, "open circuit must not reach the backend")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	var errResp ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "circuit_open", errResp.Error)
+	assert.Equal(t, http.StatusServiceUnavailable, errResp.Code)
+}
@@ -1,34 +1,475 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net/netip"
 	"os"
-	"strconv"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/acme"
+	"gopkg.in/yaml.v3"
 )
 
 // Config holds all configuration for the proxy server
 type Config struct {
-	Port            int    `json:"port"`
-	Backend         string `json:"backend"`
-	LogLevel        string `json:"log_level"`
-	CacheEnabled    bool   `json:"cache_enabled"`
-	CacheSize       int    `json:"cache_size"`
-	CacheTTL        int    `json:"cache_ttl_seconds"`
-	RequestTimeout  int    `json:"request_timeout_seconds"`
-	ShutdownTimeout int    `json:"shutdown_timeout_seconds"`
-	RateLimitEnabled bool   `json:"rate_limit_enabled"`
-	RateLimitRPM    int    `json:"rate_limit_requests_per_minute"`
-	RateLimitBurst  int    `json:"rate_limit_burst_size"`
+	Port             int             `json:"port"`
+	Backend          string          `json:"backend"`
+	Backends         []BackendConfig `json:"backends"`
+	LoadBalancer     string          `json:"load_balancer"`
+	HashKey          string          `json:"hash_key"`
+	Routes           []RouteConfig   `json:"routes"`
+	LogLevel         string          `json:"log_level"`
+	LogFormat        string          `json:"log_format"`
+	CacheEnabled     bool            `json:"cache_enabled"`
+	CacheSize        int             `json:"cache_size"`
+	CacheTTL         int             `json:"cache_ttl_seconds"`
+	RequestTimeout   int             `json:"request_timeout_seconds"`
+	ShutdownTimeout  int             `json:"shutdown_timeout_seconds"`
+	RateLimitEnabled bool            `json:"rate_limit_enabled"`
+	RateLimitRPM     int             `json:"rate_limit_requests_per_minute"`
+	RateLimitBurst   int             `json:"rate_limit_burst_size"`
+	MetricsEnabled   bool            `json:"metrics_enabled"`
+	MetricsPath      string          `json:"metrics_path"`
+	AdminEnabled     bool            `json:"admin_enabled"`
+	AdminPort        int             `json:"admin_port"`
+	AdminBindAddress string          `json:"admin_bind_address"` // empty listens on all interfaces
+
+	// How often config files are checked for changes; 0 only reloads on SIGHUP
+	ConfigReloadInterval int `json:"config_reload_interval_seconds"`
+
+	TLSEnabled          bool                   `json:"tls_enabled"`
+	TLSCertFile         string                 `json:"tls_cert_file"`
+	TLSKeyFile          string                 `json:"tls_key_file"`
+	TLSCertificates     []TLSCertificateConfig `json:"tls_certificates"` // extra certificates selected by SNI
+	TLSMinVersion       string                 `json:"tls_min_version"`
+	TLSCipherSuites     []string               `json:"tls_cipher_suites"` // empty uses Go's defaults
+	TLSReloadInterval   int                    `json:"tls_reload_interval_seconds"`
+	TLSClientAuth       string                 `json:"tls_client_auth"`       // none, optional or require
+	TLSClientCAFile     string                 `json:"tls_client_ca_file"`    // CA bundle for client certificates
+	HTTPRedirectEnabled bool                   `json:"http_redirect_enabled"` // redirect plain HTTP to HTTPS
+	HTTPRedirectPort    int                    `json:"http_redirect_port"`
+
+	ACMEEnabled       bool     `json:"acme_enabled"`
+	ACMEDirectoryURL  string   `json:"acme_directory_url"`
+	ACMEEmail         string   `json:"acme_email"`
+	ACMEDomains       []string `json:"acme_domains"`
+	ACMECacheDir      string   `json:"acme_cache_dir"`
+	ACMECAFile        string   `json:"acme_ca_file"` // CA bundle for the directory, such as Pebble's
+	ACMEHTTPChallenge bool     `json:"acme_http_challenge"`
+
+	HTTP2Enabled              bool `json:"http2_enabled"` // offer HTTP/2 to TLS clients
+	H2CEnabled                bool `json:"h2c_enabled"`   // accept cleartext HTTP/2 with prior knowledge
+	HTTP2MaxConcurrentStreams int  `json:"http2_max_concurrent_streams"`
+	HTTP2MaxConnectionWindow  int  `json:"http2_max_connection_window_bytes"`
+	HTTP2MaxStreamWindow      int  `json:"http2_max_stream_window_bytes"`
+
+	// Defaults for dialing HTTPS backends; a backend's own tls settings
+	// override them
+	BackendTLSCAFile     string `json:"backend_tls_ca_file"`
+	BackendTLSCertFile   string `json:"backend_tls_cert_file"`
+	BackendTLSKeyFile    string `json:"backend_tls_key_file"`
+	BackendTLSServerName string `json:"backend_tls_server_name"`
+
+	// Limits for upgraded connections such as WebSockets, which are exempt
+	// from request_timeout_seconds; 0 disables a limit
+	UpgradeIdleTimeout int `json:"upgrade_idle_timeout_seconds"`
+	UpgradeMaxDuration int `json:"upgrade_max_duration_seconds"`
+
+	// Streamed responses such as server-sent events are exempt from
+	// request_timeout_seconds once they start and are closed after this long
+	// without data instead; 0 disables the limit
+	StreamIdleTimeout int `json:"stream_idle_timeout_seconds"`
+
+	RequestIDHeader          string   `json:"request_id_header"`           // empty disables propagation
+	RequestIDTrustedNetworks []string `json:"request_id_trusted_networks"` // CIDRs whose incoming IDs are kept
+
+	TracingEnabled     bool    `json:"tracing_enabled"`
+	TracingEndpoint    string  `json:"tracing_endpoint"`
+	TracingServiceName string  `json:"tracing_service_name"`
+	TracingSampleRatio float64 `json:"tracing_sample_ratio"`
+
+	AccessLogEnabled        bool   `json:"access_log_enabled"`
+	AccessLogFormat         string `json:"access_log_format"`
+	AccessLogTemplate       string `json:"access_log_template"`
+	AccessLogPath           string `json:"access_log_path"`
+	AccessLogMaxSizeMB      int    `json:"access_log_max_size_mb"`
+	AccessLogRotateInterval int    `json:"access_log_rotate_interval_seconds"`
+	AccessLogMaxBackups     int    `json:"access_log_max_backups"`
+
+	HealthCheckEnabled        bool   `json:"health_check_enabled"`
+	HealthCheckType           string `json:"health_check_type"`
+	HealthCheckPath           string `json:"health_check_path"`
+	HealthCheckInterval       int    `json:"health_check_interval_seconds"`
+	HealthCheckTimeout        int    `json:"health_check_timeout_seconds"`
+	HealthCheckExpectedStatus int    `json:"health_check_expected_status"`
+	HealthCheckExpectedBody   string `json:"health_check_expected_body"`
+	HealthCheckGRPCService    string `json:"health_check_grpc_service"` // empty checks the whole server
+	HealthCheckRise           int    `json:"health_check_rise"`
+	HealthCheckFall           int    `json:"health_check_fall"`
+
+	OutlierDetectionEnabled    bool `json:"outlier_detection_enabled"`
+	OutlierConsecutiveFailures int  `json:"outlier_consecutive_failures"`
+	OutlierBaseEjection        int  `json:"outlier_base_ejection_seconds"`
+	OutlierMaxEjection         int  `json:"outlier_max_ejection_seconds"`
+	OutlierRecovery            int  `json:"outlier_recovery_seconds"`
+
+	CircuitBreakerEnabled             bool `json:"circuit_breaker_enabled"`
+	CircuitBreakerConsecutiveFailures int  `json:"circuit_breaker_consecutive_failures"`
+	CircuitBreakerErrorRate           int  `json:"circuit_breaker_error_rate_percent"`
+	CircuitBreakerMinRequests         int  `json:"circuit_breaker_min_requests"`
+	CircuitBreakerWindow              int  `json:"circuit_breaker_window_seconds"`
+	CircuitBreakerOpenTimeout         int  `json:"circuit_breaker_open_seconds"`
+	CircuitBreakerHalfOpenRequests    int  `json:"circuit_breaker_half_open_requests"`
+
+	RetryEnabled            bool  `json:"retry_enabled"`
+	RetryMaxAttempts        int   `json:"retry_max_attempts"`
+	RetryOnStatus           []int `json:"retry_on_status"`
+	RetryBackoffBase        int   `json:"retry_backoff_base_ms"`
+	RetryBackoffMax         int   `json:"retry_backoff_max_ms"`
+	RetryBudgetPercent      int   `json:"retry_budget_percent"`
+	RetryBudgetMinPerSecond int   `json:"retry_budget_min_per_second"`
+	RetryMaxBodyBytes       int64 `json:"retry_max_body_bytes"`
+}
+
+// BackendConfig describes a single upstream server
+type BackendConfig struct {
+	URL      string            `json:"url"`
+	Weight   int               `json:"weight"`
+	TLS      *BackendTLSConfig `json:"tls"`      // overrides the top-level backend_tls settings
+	Protocol string            `json:"protocol"` // auto, http1, http2 or h2c; empty means auto
+}
+
+// BackendList returns the configured backends, falling back to the single
+// backend URL when no backends list is given
+func (c *Config) BackendList() []BackendConfig {
+	if len(c.Backends) > 0 {
+		return c.Backends
+	}
+	return []BackendConfig{{URL: c.Backend, Weight: 1}}
+}
+
+// RouteList returns the configured routes with pool settings inherited from
+// the top level. Without routes, a single catch-all route forwards to the
+// top-level backends.
+func (c *Config) RouteList() []RouteConfig {
+	if len(c.Routes) == 0 {
+		return []RouteConfig{{
+			Name:         "default",
+			Backends:     c.withBackendTLS(c.BackendList()),
+			LoadBalancer: c.LoadBalancer,
+			HashKey:      c.HashKey,
+		}}
+	}
+
+	routes := make([]RouteConfig, len(c.Routes))
+	for i, route := range c.Routes {
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route-%d", i)
+		}
+		if route.LoadBalancer == "" {
+			route.LoadBalancer = c.LoadBalancer
+		}
+		if route.HashKey == "" {
+			route.HashKey = c.HashKey
+		}
+		route.Backends = c.withBackendTLS(route.Backends)
+		routes[i] = route
+	}
+	return routes
+}
+
+// BackendTLS returns the default settings for dialing HTTPS backends
+func (c *Config) BackendTLS() BackendTLSConfig {
+	return BackendTLSConfig{
+		CAFile:     c.BackendTLSCAFile,
+		CertFile:   c.BackendTLSCertFile,
+		KeyFile:    c.BackendTLSKeyFile,
+		ServerName: c.BackendTLSServerName,
+	}
+}
+
+// withBackendTLS returns a copy of backends with the top-level backend TLS
+// settings filled in for HTTPS backends
+func (c *Config) withBackendTLS(backends []BackendConfig) []BackendConfig {
+	defaults := c.BackendTLS()
+	if defaults == (BackendTLSConfig{}) {
+		return backends
+	}
+
+	result := make([]BackendConfig, len(backends))
+	for i, backend := range backends {
+		if strings.HasPrefix(backend.URL, "https://") {
+			var own BackendTLSConfig
+			if backend.TLS != nil {
+				own = *backend.TLS
+			}
+			merged := own.withDefaults(defaults)
+			backend.TLS = &merged
+		}
+		result[i] = backend
+	}
+	return result
+}
+
+// HealthCheck returns the active health check settings
+func (c *Config) HealthCheck() HealthCheckConfig {
+	return HealthCheckConfig{
+		Type:           c.HealthCheckType,
+		Path:           c.HealthCheckPath,
+		Interval:       time.Duration(c.HealthCheckInterval) * time.Second,
+		Timeout:        time.Duration(c.HealthCheckTimeout) * time.Second,
+		ExpectedStatus: c.HealthCheckExpectedStatus,
+		ExpectedBody:   c.HealthCheckExpectedBody,
+		GRPCService:    c.HealthCheckGRPCService,
+		Rise:           c.HealthCheckRise,
+		Fall:           c.HealthCheckFall,
+	}
+}
+
+// RequestID returns the request ID settings. Invalid networks are skipped;
+// Validate reports them.
+func (c *Config) RequestID() RequestIDConfig {
+	config := RequestIDConfig{Header: c.RequestIDHeader}
+	for _, network := range c.RequestIDTrustedNetworks {
+		if prefix, err := netip.ParsePrefix(network); err == nil {
+			config.Trusted = append(config.Trusted, prefix.Masked())
+		}
+	}
+	return config
+}
+
+// TLS returns the TLS listener settings. The certificate in tls_cert_file
+// comes first so it is the default. Unknown cipher suites are skipped;
+// Validate reports them.
+func (c *Config) TLS() TLSConfig {
+	config := TLSConfig{
+		MinVersion:     tlsVersions[c.TLSMinVersion],
+		ReloadInterval: time.Duration(c.TLSReloadInterval) * time.Second,
+		ClientAuth:     c.TLSClientAuth,
+		ClientCAFile:   c.TLSClientCAFile,
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		config.Certificates = append(config.Certificates, TLSCertificateConfig{CertFile: c.TLSCertFile, KeyFile: c.TLSKeyFile})
+	}
+	config.Certificates = append(config.Certificates, c.TLSCertificates...)
+	for _, name := range c.TLSCipherSuites {
+		if id, ok := cipherSuiteID(name); ok {
+			config.CipherSuites = append(config.CipherSuites, id)
+		}
+	}
+	return config
+}
+
+// ACME returns the automatic certificate management settings
+func (c *Config) ACME() ACMEConfig {
+	return ACMEConfig{
+		DirectoryURL:  c.ACMEDirectoryURL,
+		Email:         c.ACMEEmail,
+		Domains:       c.ACMEDomains,
+		CacheDir:      c.ACMECacheDir,
+		CAFile:        c.ACMECAFile,
+		HTTPChallenge: c.ACMEHTTPChallenge,
+	}
+}
+
+// HTTP2 returns the HTTP/2 settings for client connections
+func (c *Config) HTTP2() HTTP2Config {
+	return HTTP2Config{
+		Enabled:              c.HTTP2Enabled,
+		H2C:                  c.H2CEnabled,
+		MaxConcurrentStreams: c.HTTP2MaxConcurrentStreams,
+		MaxConnectionWindow:  c.HTTP2MaxConnectionWindow,
+		MaxStreamWindow:      c.HTTP2MaxStreamWindow,
+	}
+}
+
+// Upgrade returns the limits for upgraded connections
+func (c *Config) Upgrade() UpgradeConfig {
+	return UpgradeConfig{
+		IdleTimeout: time.Duration(c.UpgradeIdleTimeout) * time.Second,
+		MaxDuration: time.Duration(c.UpgradeMaxDuration) * time.Second,
+	}
+}
+
+// Tracing returns the tracing settings
+func (c *Config) Tracing() TracingConfig {
+	return TracingConfig{
+		Endpoint:    c.TracingEndpoint,
+		ServiceName: c.TracingServiceName,
+		SampleRatio: c.TracingSampleRatio,
+	}
+}
+
+// AccessLog returns the access log settings
+func (c *Config) AccessLog() AccessLogConfig {
+	return AccessLogConfig{
+		Format:         c.AccessLogFormat,
+		Template:       c.AccessLogTemplate,
+		Path:           c.AccessLogPath,
+		MaxSize:        int64(c.AccessLogMaxSizeMB) << 20,
+		RotateInterval: time.Duration(c.AccessLogRotateInterval) * time.Second,
+		MaxBackups:     c.AccessLogMaxBackups,
+	}
+}
+
+// OutlierDetection returns the passive outlier detection settings
+func (c *Config) OutlierDetection() OutlierConfig {
+	return OutlierConfig{
+		ConsecutiveFailures: c.OutlierConsecutiveFailures,
+		BaseEjection:        time.Duration(c.OutlierBaseEjection) * time.Second,
+		MaxEjection:         time.Duration(c.OutlierMaxEjection) * time.Second,
+		Recovery:            time.Duration(c.OutlierRecovery) * time.Second,
+	}
+}
+
+// CircuitBreaker returns the per-backend circuit breaker settings
+func (c *Config) CircuitBreaker() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		ConsecutiveFailures: c.CircuitBreakerConsecutiveFailures,
+		ErrorRatePercent:    c.CircuitBreakerErrorRate,
+		MinRequests:         c.CircuitBreakerMinRequests,
+		Window:              time.Duration(c.CircuitBreakerWindow) * time.Second,
+		OpenTimeout:         time.Duration(c.CircuitBreakerOpenTimeout) * time.Second,
+		HalfOpenRequests:    c.CircuitBreakerHalfOpenRequests,
+	}
+}
+
+// Retry returns the upstream retry settings
+func (c *Config) Retry() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:        c.RetryMaxAttempts,
+		RetryOnStatus:      c.RetryOnStatus,
+		BackoffBase:        time.Duration(c.RetryBackoffBase) * time.Millisecond,
+		BackoffMax:         time.Duration(c.RetryBackoffMax) * time.Millisecond,
+		BudgetPercent:      c.RetryBudgetPercent,
+		BudgetMinPerSecond: c.RetryBudgetMinPerSecond,
+		MaxBodyBytes:       c.RetryMaxBodyBytes,
+	}
 }
 
 // LoadConfig loads configuration from environment variables, config file, and command-line flags
 func LoadConfig() (*Config, error) {
-	config := &Config{
+	// Parse command-line flags (only if not already parsed)
+	if !flag.Parsed() {
+		commandLine = parseCommandLine()
+	}
+	return ReloadConfig()
+}
+
+// ReloadConfig loads the configuration again from the same sources as
+// LoadConfig, reusing the command-line flags it parsed
+func ReloadConfig() (*Config, error) {
+	config := defaultConfig()
+
+	// Load the config files, then environment variables, then flags, each
+	// overriding the last. Unknown keys are reported together with the
+	// validation errors.
+	configFile := ""
+	if commandLine != nil {
+		configFile = *commandLine.configFile
+	}
+	var errs ValidationErrors
+	if err := loadConfigFromEnvAndFile(config, configFile); err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+
+	// Apply command-line flags
+	if cl := commandLine; cl != nil {
+		for _, f := range cl.fields {
+			if cl.set[f.field.flagName()] {
+				// Values were checked when the flags were parsed
+				f.field.set(config, f.value)
+			}
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		var invalid ValidationErrors
+		if !errors.As(err, &invalid) {
+			return nil, err
+		}
+		errs = append(errs, invalid...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return config, nil
+}
+
+// CheckConfigOnly reports whether the proxy was started with -validate or
+// the check-config command, which only validate the configuration
+func CheckConfigOnly() bool {
+	return commandLine != nil && (*commandLine.validate || commandLine.checkConfig)
+}
+
+// ConfigFiles returns the config files the configuration is loaded from
+func ConfigFiles() []string {
+	var files []string
+	if configFile := os.Getenv("PROXY_CONFIG_FILE"); configFile != "" {
+		files = append(files, configFile)
+	}
+	if commandLine != nil && *commandLine.configFile != "" {
+		files = append(files, *commandLine.configFile)
+	}
+	return files
+}
+
+// commandLineFlags holds the parsed command-line flags
+type commandLineFlags struct {
+	fields     []*configFlag // one per Config field
+	configFile *string
+	validate   *bool
+	set        map[string]bool // flags given explicitly
+
+	checkConfig bool // started as "reverse-proxy check-config"
+}
+
+// commandLine is set by the first LoadConfig when it parses the flags
+var commandLine *commandLineFlags
+
+func parseCommandLine() *commandLineFlags {
+	defaults := defaultConfig()
+	cl := &commandLineFlags{
+		configFile: flag.String("config", "", "Path to config file"),
+		validate:   flag.Bool("validate", false, "Validate the configuration and exit"),
+		set:        make(map[string]bool),
+	}
+	for _, field := range configFields() {
+		f := &configFlag{field: field, value: field.get(defaults)}
+		flag.Var(f, field.flagName(), field.usage())
+		cl.fields = append(cl.fields, f)
+	}
+
+	flag.Parse()
+
+	// Flags may also follow the check-config command
+	if flag.Arg(0) == "check-config" {
+		cl.checkConfig = true
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	flag.Visit(func(f *flag.Flag) { cl.set[f.Name] = true })
+
+	return cl
+}
+
+// defaultConfig returns the configuration used when nothing else is set
+func defaultConfig() *Config {
+	return &Config{
 		Port:             8080,
 		Backend:          "http://127.0.0.1:5000",
+		LoadBalancer:     StrategyRoundRobin,
+		HashKey:          "client_ip",
 		LogLevel:         "info",
+		LogFormat:        LogFormatText,
 		CacheEnabled:     false,
 		CacheSize:        100,
 		CacheTTL:         300, // 5 minutes
@@ -37,84 +478,283 @@ func LoadConfig() (*Config, error) {
 		RateLimitEnabled: false,
 		RateLimitRPM:     100, // 100 requests per minute
 		RateLimitBurst:   20,  // burst size
-	}
+		MetricsEnabled:   false,
+		MetricsPath:      "/metrics",
+		AdminEnabled:     false,
+		AdminPort:        9901,
+		AdminBindAddress: "127.0.0.1",
 
-	// Load from environment variables and config file
-	if err := loadConfigFromEnvAndFile(config); err != nil {
-		return nil, err
-	}
+		ConfigReloadInterval: 5, // 5 seconds
 
-	// Apply command-line flags (only if not already parsed)
-	if !flag.Parsed() {
-		portFlag := flag.Int("port", config.Port, "Port to listen on")
-		backendFlag := flag.String("backend", config.Backend, "Backend server URL")
-		logLevelFlag := flag.String("log-level", config.LogLevel, "Log level (debug, info, warn, error)")
-		configFileFlag := flag.String("config", "", "Path to config file")
-
-		flag.Parse()
-
-		// Apply command-line flags
-		config.Port = *portFlag
-		config.Backend = *backendFlag
-		config.LogLevel = *logLevelFlag
-
-		// Load config file from flag if specified
-		if *configFileFlag != "" {
-			if err := loadConfigFromFile(*configFileFlag, config); err != nil {
-				return nil, fmt.Errorf("failed to load config file: %v", err)
-			}
-		}
+		TLSEnabled:          false,
+		TLSMinVersion:       "1.2",
+		TLSReloadInterval:   30, // 30 seconds
+		TLSClientAuth:       ClientAuthNone,
+		HTTPRedirectEnabled: false,
+		HTTPRedirectPort:    80,
+
+		ACMEEnabled:       false,
+		ACMEDirectoryURL:  acme.LetsEncryptURL,
+		ACMECacheDir:      "acme-cache",
+		ACMEHTTPChallenge: true,
+
+		HTTP2Enabled:              true,
+		H2CEnabled:                false,
+		HTTP2MaxConcurrentStreams: 250,
+		HTTP2MaxConnectionWindow:  1 << 20, // 1 MiB
+		HTTP2MaxStreamWindow:      1 << 20, // 1 MiB
+
+		UpgradeIdleTimeout: 300, // 5 minutes
+		UpgradeMaxDuration: 0,   // no limit
+
+		StreamIdleTimeout: 60, // 1 minute
+
+		RequestIDHeader: "X-Request-ID",
+
+		TracingEnabled:     false,
+		TracingEndpoint:    "http://localhost:4318/v1/traces",
+		TracingServiceName: "reverse-proxy",
+		TracingSampleRatio: 1.0,
+
+		AccessLogEnabled:        false,
+		AccessLogFormat:         AccessLogCombined,
+		AccessLogPath:           AccessLogStdout,
+		AccessLogMaxSizeMB:      100,
+		AccessLogRotateInterval: 0, // no time-based rotation
+		AccessLogMaxBackups:     7,
+
+		HealthCheckEnabled:  false,
+		HealthCheckType:     HealthCheckHTTP,
+		HealthCheckPath:     "/health",
+		HealthCheckInterval: 10, // 10 seconds
+		HealthCheckTimeout:  2,  // 2 seconds
+		HealthCheckRise:     2,  // consecutive successes to become healthy
+		HealthCheckFall:     3,  // consecutive failures to become unhealthy
+
+		OutlierDetectionEnabled:    false,
+		OutlierConsecutiveFailures: 5,
+		OutlierBaseEjection:        30,  // 30 seconds
+		OutlierMaxEjection:         300, // 5 minutes
+		OutlierRecovery:            30,  // 30 seconds ramp-up
+
+		CircuitBreakerEnabled:             false,
+		CircuitBreakerConsecutiveFailures: 5,
+		CircuitBreakerErrorRate:           50, // percent
+		CircuitBreakerMinRequests:         20,
+		CircuitBreakerWindow:              10, // 10 seconds
+		CircuitBreakerOpenTimeout:         30, // 30 seconds
+		CircuitBreakerHalfOpenRequests:    3,
+
+		RetryEnabled:            false,
+		RetryMaxAttempts:        3,
+		RetryOnStatus:           []int{502, 503, 504},
+		RetryBackoffBase:        25,   // 25 milliseconds
+		RetryBackoffMax:         1000, // 1 second
+		RetryBudgetPercent:      20,   // retries may add at most 20% load
+		RetryBudgetMinPerSecond: 3,
+		RetryMaxBodyBytes:       1 << 20, // 1 MiB
 	}
 
-	return config, nil
 }
 
-// loadConfigFromEnvAndFile loads configuration from environment variables and config file
-func loadConfigFromEnvAndFile(config *Config) error {
-	// Load from config file first (if it exists)
-	if configFile := os.Getenv("PROXY_CONFIG_FILE"); configFile != "" {
-		if err := loadConfigFromFile(configFile, config); err != nil {
-			return fmt.Errorf("failed to load config file: %v", err)
+// loadConfigFromEnvAndFile loads configuration from the PROXY_CONFIG_FILE
+// file, then configFile if not empty, then environment variables, which
+// override both files
+func loadConfigFromEnvAndFile(config *Config, configFile string) error {
+	// Load from config files first (if they exist). Unknown keys are
+	// returned after the environment is applied.
+	var unknown ValidationErrors
+	for _, file := range []string{os.Getenv("PROXY_CONFIG_FILE"), configFile} {
+		if file == "" {
+			continue
 		}
-	}
-
-	// Environment variables override config file
-	if portStr := os.Getenv("PROXY_PORT"); portStr != "" {
-		if port, err := strconv.Atoi(portStr); err == nil {
-			config.Port = port
+		var fileUnknown ValidationErrors
+		if err := loadConfigFromFile(file, config); err != nil && !errors.As(err, &fileUnknown) {
+			return fmt.Errorf("failed to load config file: %v", err)
 		}
+		unknown = append(unknown, fileUnknown...)
 	}
 
-	if backend := os.Getenv("PROXY_BACKEND"); backend != "" {
-		config.Backend = backend
+	// Environment variables override config files
+	if err := loadConfigFromEnv(config); err != nil {
+		return err
 	}
-
-	if logLevel := os.Getenv("PROXY_LOG_LEVEL"); logLevel != "" {
-		config.LogLevel = logLevel
+	if len(unknown) > 0 {
+		return unknown
 	}
-
 	return nil
 }
 
 // LoadTestConfig loads configuration for testing (without parsing flags)
 func LoadTestConfig() (*Config, error) {
 	config := &Config{
-		Port:     8080,
-		Backend:  "http://127.0.0.1:5000",
-		LogLevel: "info",
+		Port:         8080,
+		Backend:      "http://127.0.0.1:5000",
+		LoadBalancer: StrategyRoundRobin,
+		HashKey:      "client_ip",
+		LogLevel:     "info",
 	}
 
-	return config, loadConfigFromEnvAndFile(config)
+	return config, loadConfigFromEnvAndFile(config, "")
 }
 
-// loadConfigFromFile loads configuration from a JSON file
+// loadConfigFromFile loads configuration from a JSON, YAML or TOML file,
+// chosen by the file extension. Unknown fields are returned as
+// ValidationErrors after the rest of the file is decoded. Files with other
+// extensions are read as JSON.
 func loadConfigFromFile(filename string, config *Config) error {
-	file, err := os.Open(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	return decoder.Decode(config)
+	// YAML and TOML are converted to JSON so every format is decoded with
+	// the same schema and rules
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+	case ".toml":
+		var table map[string]interface{}
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		doc = table
+	default:
+		return decodeConfigJSON(filename, data, config, true)
+	}
+
+	if doc == nil {
+		return nil // empty file
+	}
+	converted, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return decodeConfigJSON(filename, converted, config, false)
+}
+
+// decodeConfigJSON decodes JSON into config, reporting unknown fields.
+// Positions are only reported for errors in files that are JSON themselves.
+func decodeConfigJSON(filename string, data []byte, config *Config, isSource bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(config); err != nil {
+		if !isSource {
+			data = nil
+		}
+		return describeJSONError(filename, data, err)
+	}
+
+	// Unknown keys are reported as validation errors, so a typo does not
+	// hide the problems Validate finds in the rest of the file
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	var errs ValidationErrors
+	for _, path := range unknownFields("", doc, reflect.TypeOf(config)) {
+		key := path[strings.LastIndexAny(path, ".]")+1:]
+		errs = append(errs, ValidationError{Field: path, Message: fmt.Sprintf("unknown field %q in %s", key, filename)})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// unknownFields returns the paths of the keys in doc, a decoded JSON value,
+// that no field of typ would be decoded from
+func unknownFields(path string, doc interface{}, typ reflect.Type) []string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	var paths []string
+	switch value := doc.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			switch typ.Kind() {
+			case reflect.Struct:
+				field, ok := jsonField(typ, key)
+				if !ok {
+					paths = append(paths, keyPath)
+					continue
+				}
+				paths = append(paths, unknownFields(keyPath, value[key], field.Type)...)
+			case reflect.Map:
+				paths = append(paths, unknownFields(keyPath, value[key], typ.Elem())...)
+			}
+		}
+	case []interface{}:
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			for i, elem := range value {
+				paths = append(paths, unknownFields(fmt.Sprintf("%s[%d]", path, i), elem, typ.Elem())...)
+			}
+		}
+	}
+	return paths
+}
+
+// jsonField returns the struct field decoded from key, matching names the
+// way encoding/json does: exactly first, then case-insensitively
+func jsonField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	var folded *reflect.StructField
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == key {
+			return field, true
+		}
+		if folded == nil && strings.EqualFold(name, key) {
+			folded = &field
+		}
+	}
+	if folded != nil {
+		return *folded, true
+	}
+	return reflect.StructField{}, false
+}
+
+// describeJSONError adds the file name and, where known, the line and
+// column of the problem to a JSON decoding error
+func describeJSONError(filename string, data []byte, err error) error {
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		err = fmt.Errorf("%s: cannot use %s as %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	}
+
+	if offset < 0 || offset > int64(len(data)) || data == nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("%s:%d:%d: %v", filename, line, column, err)
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -99,3 +102,225 @@ func TestLoadConfig_MissingFile(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to load config file")
 }
+
+func TestLoadConfig_Backends(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	helper.SetEnv("PROXY_CONFIG_FILE", "testdata/backends_config.json")
+
+	config, err := LoadTestConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "consistent_hash", config.LoadBalancer)
+	assert.Equal(t, "header:X-User-ID", config.HashKey)
+	assert.Equal(t, []BackendConfig{
+		{URL: "http://backend-1.test", Weight: 3},
+		{URL: "http://backend-2.test"},
+	}, config.BackendList())
+}
+
+func TestConfig_BackendListFallsBackToBackend(t *testing.T) {
+	config := &Config{Backend: "http://single.test"}
+	assert.Equal(t, []BackendConfig{{URL: "http://single.test", Weight: 1}}, config.BackendList())
+}
+
+func TestConfig_RouteListInheritsPoolSettings(t *testing.T) {
+	config := &Config{
+		LoadBalancer: StrategyLeastConnections,
+		HashKey:      "client_ip",
+		Routes: []RouteConfig{
+			{PathPrefix: "/a"},
+			{Name: "b", LoadBalancer: StrategyConsistentHash, HashKey: "cookie:id"},
+		},
+	}
+
+	routes := config.RouteList()
+	assert.Equal(t, "route-0", routes[0].Name)
+	assert.Equal(t, StrategyLeastConnections, routes[0].LoadBalancer)
+	assert.Equal(t, "b", routes[1].Name)
+	assert.Equal(t, StrategyConsistentHash, routes[1].LoadBalancer)
+	assert.Equal(t, "cookie:id", routes[1].HashKey)
+}
+
+func TestReloadConfig_ReadsFileAgain(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"port": 9090}`), 0o644))
+	helper.SetEnv("PROXY_CONFIG_FILE", file)
+
+	config, err := ReloadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, config.Port)
+	assert.Equal(t, 100, config.CacheSize)
+	assert.Equal(t, []string{file}, ConfigFiles())
+
+	assert.NoError(t, os.WriteFile(file, []byte(`{"port": 9191}`), 0o644))
+	config, err = ReloadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 9191, config.Port)
+}
+
+func TestReloadConfig_FlagsAndEnvOverrideFile(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"port": 9090, "cache_size": 50, "log_level": "warn"}`), 0o644))
+
+	// Stand in for "-config <file> -port 9191"
+	var flags []*configFlag
+	for _, field := range configFields() {
+		if field.name == "port" {
+			flags = append(flags, &configFlag{field: field, value: "9191"})
+		}
+	}
+	defer func(saved *commandLineFlags) { commandLine = saved }(commandLine)
+	commandLine = &commandLineFlags{
+		fields:     flags,
+		configFile: &file,
+		validate:   new(bool),
+		set:        map[string]bool{"port": true},
+	}
+	helper.SetEnv("PROXY_PORT", "9292")
+	helper.SetEnv("PROXY_CACHE_SIZE", "70")
+
+	config, err := ReloadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 9191, config.Port, "flags override the environment and the file")
+	assert.Equal(t, 70, config.CacheSize, "the environment overrides the file")
+	assert.Equal(t, "warn", config.LogLevel)
+
+	// Flags are validated like the file
+	commandLine.fields[0].value = "70000"
+	_, err = ReloadConfig()
+	assert.ErrorContains(t, err, "port: must be between 1 and 65535, got 70000")
+}
+
+func TestLoadConfig_UnknownField(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	helper.SetEnv("PROXY_CONFIG_FILE", "testdata/unknown_field_config.json")
+
+	_, err := LoadTestConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "testdata/unknown_field_config.json")
+	assert.Contains(t, err.Error(), `unknown field "cache_sise"`)
+}
+
+func TestReloadConfig_ReportsUnknownFieldsWithValidationErrors(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{
+  "port": -1,
+  "cache_sise": 10,
+  "routes": [{"name": "api", "path_prefx": "/api/", "backends": [{"url": "http://api", "wieght": 2}]}]
+}`), 0o644))
+	helper.SetEnv("PROXY_CONFIG_FILE", file)
+
+	_, err := ReloadConfig()
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	fields := []string{}
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.ElementsMatch(t, []string{"cache_sise", "routes[0].path_prefx", "routes[0].backends[0].wieght", "port"}, fields)
+	assert.Contains(t, err.Error(), `routes[0].backends[0].wieght: unknown field "wieght" in `+file)
+}
+
+func TestLoadConfig_TypeErrorPosition(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	helper.SetEnv("PROXY_CONFIG_FILE", "testdata/type_error_config.json")
+
+	_, err := LoadTestConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "testdata/type_error_config.json:4:")
+	assert.Contains(t, err.Error(), "priority: cannot use string as int")
+}
+
+func TestReloadConfig_Validates(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"port": 0, "cache_size": -5}`), 0o644))
+	helper.SetEnv("PROXY_CONFIG_FILE", file)
+
+	_, err := ReloadConfig()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2 configuration error(s)")
+	assert.Contains(t, err.Error(), "port:")
+	assert.Contains(t, err.Error(), "cache_size:")
+}
+
+func TestLoadConfig_YAMLAndTOMLFiles(t *testing.T) {
+	for _, file := range []string{"testdata/config.yaml", "testdata/config.toml"} {
+		t.Run(file, func(t *testing.T) {
+			helper := SetupTestEnv()
+			defer helper.RestoreEnv()
+
+			helper.SetEnv("PROXY_CONFIG_FILE", file)
+
+			config, err := LoadTestConfig()
+			assert.NoError(t, err)
+			assert.Equal(t, 9090, config.Port)
+			assert.Equal(t, "http://test-backend.com", config.Backend)
+			assert.Equal(t, "debug", config.LogLevel)
+		})
+	}
+}
+
+func TestLoadConfig_NestedYAMLAndTOML(t *testing.T) {
+	for _, file := range []string{"testdata/routes_config.yml", "testdata/routes_config.toml"} {
+		t.Run(file, func(t *testing.T) {
+			config := defaultConfig()
+			assert.NoError(t, loadConfigFromFile(file, config))
+			assert.NoError(t, config.Validate())
+
+			assert.True(t, config.CacheEnabled)
+			assert.Equal(t, []int{502, 503}, config.RetryOnStatus)
+			assert.Len(t, config.Routes, 2)
+
+			api := config.Routes[0]
+			assert.Equal(t, "api", api.Name)
+			assert.Equal(t, "/api/", api.PathPrefix)
+			assert.Equal(t, []string{"GET", "POST"}, api.Methods)
+			assert.Equal(t, map[string]string{"X-Tenant": "acme"}, api.Headers)
+			assert.Equal(t, []BackendConfig{{URL: "http://api-1.test", Weight: 2}, {URL: "http://api-2.test"}}, api.Backends)
+			assert.Equal(t, 30, api.RateLimit.RequestsPerMinute)
+			assert.Equal(t, []BackendConfig{{URL: "http://web.test"}}, config.Routes[1].Backends)
+		})
+	}
+}
+
+func TestLoadConfig_YAMLUnknownField(t *testing.T) {
+	err := loadConfigFromFile("testdata/unknown_field_config.yaml", defaultConfig())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "testdata/unknown_field_config.yaml")
+	assert.Contains(t, err.Error(), `unknown field "path_prefx"`)
+}
+
+func TestLoadConfig_InvalidYAML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("port: [9090\n"), 0o644))
+
+	err := loadConfigFromFile(file, defaultConfig())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "yaml:")
+}
+
+func TestLoadConfig_EmptyYAML(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	config := defaultConfig()
+	assert.NoError(t, loadConfigFromFile(file, config))
+	assert.Equal(t, 8080, config.Port)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is prepended to a field's upper-cased JSON name to form its
+// environment variable, such as PROXY_CACHE_TTL_SECONDS
+const envPrefix = "PROXY_"
+
+// configField is a top-level Config field that can be set from an
+// environment variable or a command-line flag. Fields are derived from the
+// JSON struct tags, so new fields are covered automatically.
+type configField struct {
+	name  string // JSON name, such as "cache_ttl_seconds"
+	index int
+	typ   reflect.Type
+}
+
+// configFields returns every Config field with a JSON name
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+	fields := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, configField{name: name, index: i, typ: t.Field(i).Type})
+	}
+	return fields
+}
+
+// envName returns the environment variable for the field
+func (f configField) envName() string {
+	return envPrefix + strings.ToUpper(f.name)
+}
+
+// flagName returns the command-line flag for the field, such as
+// "cache-ttl-seconds"
+func (f configField) flagName() string {
+	return strings.ReplaceAll(f.name, "_", "-")
+}
+
+// usage describes the field in the flag help text
+func (f configField) usage() string {
+	switch kind := f.typ.Kind(); {
+	case kind == reflect.Slice && isScalar(f.typ.Elem().Kind()):
+		return fmt.Sprintf("Comma-separated %s (env %s)", f.name, f.envName())
+	case kind == reflect.Slice || kind == reflect.Map || kind == reflect.Struct:
+		return fmt.Sprintf("JSON value of %s (env %s)", f.name, f.envName())
+	default:
+		return fmt.Sprintf("Sets %s (env %s)", f.name, f.envName())
+	}
+}
+
+// get formats the field's value in the form accepted by set
+func (f configField) get(config *Config) string {
+	v := reflect.ValueOf(config).Elem().Field(f.index)
+	switch {
+	case isScalar(v.Kind()):
+		return fmt.Sprint(v.Interface())
+	case v.Kind() == reflect.Slice && isScalar(f.typ.Elem().Kind()):
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(parts, ",")
+	case v.IsNil():
+		return ""
+	default:
+		data, _ := json.Marshal(v.Interface())
+		return string(data)
+	}
+}
+
+// set parses value and stores it in the field. Scalars use their Go syntax,
+// lists of scalars are comma-separated and anything else is JSON.
+func (f configField) set(config *Config, value string) error {
+	v := reflect.ValueOf(config).Elem().Field(f.index)
+
+	if isScalar(v.Kind()) {
+		return setScalar(v, value)
+	}
+	if v.Kind() == reflect.Slice && isScalar(f.typ.Elem().Kind()) {
+		var parts []string
+		if strings.TrimSpace(value) != "" {
+			parts = strings.Split(value, ",")
+		}
+		list := reflect.MakeSlice(f.typ, len(parts), len(parts))
+		for i, part := range parts {
+			if err := setScalar(list.Index(i), strings.TrimSpace(part)); err != nil {
+				return fmt.Errorf("item %d: %v", i, err)
+			}
+		}
+		v.Set(list)
+		return nil
+	}
+
+	parsed := reflect.New(f.typ)
+	if err := json.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+		return fmt.Errorf("must be JSON: %v", err)
+	}
+	v.Set(parsed.Elem())
+	return nil
+}
+
+func isScalar(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func setScalar(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", value)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer, got %q", value)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number, got %q", value)
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// loadConfigFromEnv sets every field that has its environment variable set,
+// returning ValidationErrors naming each variable with an invalid value
+func loadConfigFromEnv(config *Config) error {
+	var errs ValidationErrors
+	for _, field := range configFields() {
+		value := os.Getenv(field.envName())
+		if value == "" {
+			continue
+		}
+		if err := field.set(config, value); err != nil {
+			errs = append(errs, ValidationError{Field: field.envName(), Message: err.Error()})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// configFlag is a flag.Value for a Config field. The raw value is checked
+// when the flag is parsed and applied to a Config later.
+type configFlag struct {
+	field configField
+	value string
+}
+
+func (cf *configFlag) String() string {
+	if cf == nil {
+		return ""
+	}
+	return cf.value
+}
+
+func (cf *configFlag) Set(value string) error {
+	if err := cf.field.set(&Config{}, value); err != nil {
+		return err
+	}
+	cf.value = value
+	return nil
+}
+
+// IsBoolFlag lets boolean fields be given as -cache-enabled without a value
+func (cf *configFlag) IsBoolFlag() bool {
+	return cf.field.typ.Kind() == reflect.Bool
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFields_CoverEveryTaggedField(t *testing.T) {
+	fields := configFields()
+	assert.Equal(t, reflect.TypeOf(Config{}).NumField(), len(fields))
+
+	byName := make(map[string]configField)
+	for _, field := range fields {
+		byName[field.name] = field
+	}
+	ttl := byName["cache_ttl_seconds"]
+	assert.Equal(t, "PROXY_CACHE_TTL_SECONDS", ttl.envName())
+	assert.Equal(t, "cache-ttl-seconds", ttl.flagName())
+	assert.Equal(t, "log-level", byName["log_level"].flagName())
+}
+
+func TestLoadConfigFromEnv_AllKinds(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	helper.SetEnv("PROXY_CACHE_ENABLED", "true")
+	helper.SetEnv("PROXY_CACHE_TTL_SECONDS", "60")
+	helper.SetEnv("PROXY_REQUEST_TIMEOUT_SECONDS", "10")
+	helper.SetEnv("PROXY_RATE_LIMIT_REQUESTS_PER_MINUTE", "600")
+	helper.SetEnv("PROXY_RETRY_MAX_BODY_BYTES", "2048")
+	helper.SetEnv("PROXY_TRACING_SAMPLE_RATIO", "0.25")
+	helper.SetEnv("PROXY_RETRY_ON_STATUS", "500, 503")
+	helper.SetEnv("PROXY_BACKENDS", `[{"url":"http://a.test","weight":2}]`)
+
+	config, err := LoadTestConfig()
+	assert.NoError(t, err)
+	assert.True(t, config.CacheEnabled)
+	assert.Equal(t, 60, config.CacheTTL)
+	assert.Equal(t, 10, config.RequestTimeout)
+	assert.Equal(t, 600, config.RateLimitRPM)
+	assert.Equal(t, int64(2048), config.RetryMaxBodyBytes)
+	assert.Equal(t, 0.25, config.TracingSampleRatio)
+	assert.Equal(t, []int{500, 503}, config.RetryOnStatus)
+	assert.Equal(t, []BackendConfig{{URL: "http://a.test", Weight: 2}}, config.Backends)
+}
+
+func TestLoadConfigFromEnv_InvalidValues(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	helper.SetEnv("PROXY_PORT", "eighty")
+	helper.SetEnv("PROXY_CACHE_ENABLED", "sometimes")
+	helper.SetEnv("PROXY_RETRY_ON_STATUS", "502,oops")
+	helper.SetEnv("PROXY_ROUTES", "[{")
+	helper.SetEnv("PROXY_TRACING_SAMPLE_RATIO", "half")
+
+	_, err := LoadTestConfig()
+	assert.Error(t, err)
+
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 5)
+	assert.Contains(t, err.Error(), `PROXY_PORT: must be an integer, got "eighty"`)
+	assert.Contains(t, err.Error(), `PROXY_CACHE_ENABLED: must be true or false, got "sometimes"`)
+	assert.Contains(t, err.Error(), `PROXY_RETRY_ON_STATUS: item 1: must be an integer, got "oops"`)
+	assert.Contains(t, err.Error(), "PROXY_ROUTES: must be JSON")
+	assert.Contains(t, err.Error(), `PROXY_TRACING_SAMPLE_RATIO: must be a number, got "half"`)
+}
+
+func TestConfigField_GetRoundTrips(t *testing.T) {
+	config := defaultConfig()
+	config.Routes = []RouteConfig{{Name: "api", Backends: []BackendConfig{{URL: "http://api.test"}}}}
+
+	for _, field := range configFields() {
+		copied := &Config{}
+		if value := field.get(config); value != "" {
+			assert.NoError(t, field.set(copied, value), field.name)
+		}
+	}
+}
+
+func TestConfigFlag_ParsesEveryField(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := make(map[string]*configFlag)
+	for _, field := range configFields() {
+		f := &configFlag{field: field}
+		fs.Var(f, field.flagName(), field.usage())
+		flags[field.name] = f
+	}
+
+	err := fs.Parse([]string{"-cache-enabled", "-cache-ttl-seconds", "45", "-retry-on-status", "502,504"})
+	assert.NoError(t, err)
+
+	config := defaultConfig()
+	for _, name := range []string{"cache_enabled", "cache_ttl_seconds", "retry_on_status"} {
+		assert.NoError(t, flags[name].field.set(config, flags[name].value))
+	}
+	assert.True(t, config.CacheEnabled)
+	assert.Equal(t, 45, config.CacheTTL)
+	assert.Equal(t, []int{502, 504}, config.RetryOnStatus)
+
+	fs.SetOutput(io.Discard)
+	err = fs.Parse([]string{"-cache-size", "big"})
+	assert.ErrorContains(t, err, `invalid value "big" for flag -cache-size: must be an integer`)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes the proxy reports for its own failures
+const (
+	grpcUnknown           = 2
+	grpcDeadlineExceeded  = 4
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// isGRPCRequest reports whether the request is a gRPC call
+func isGRPCRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if rest, ok := strings.CutPrefix(contentType, "application/grpc"); ok {
+		return rest == "" || rest[0] == '+' || rest[0] == ';'
+	}
+	return false
+}
+
+// grpcRoutePath returns the path selected by a route's gRPC matchers and
+// whether it must match exactly rather than as a prefix
+func grpcRoutePath(service, method string) (string, bool) {
+	if method != "" {
+		return "/" + service + "/" + method, true
+	}
+	return "/" + service + "/", false
+}
+
+// grpcStatusForHTTP maps the HTTP status of a proxy error to a gRPC status
+func grpcStatusForHTTP(status int) int {
+	switch status {
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusInternalServerError:
+		return grpcInternal
+	default:
+		return grpcUnknown
+	}
+}
+
+// writeGRPCError responds with a trailers-only gRPC response, which gRPC
+// clients read as the call's status
+func writeGRPCError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", encodeGRPCMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes a status message as the gRPC protocol
+// requires for the grpc-message header
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcFrame prefixes an uncompressed message with its gRPC length header
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// readGRPCFrame returns the single uncompressed message in data
+func readGRPCFrame(data []byte) ([]byte, error) {
+	if len(data) < 5 {
+		return nil, errors.New("truncated gRPC message")
+	}
+	if data[0] != 0 {
+		return nil, errors.New("compressed gRPC messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(data[1:5])
+	if uint32(len(data)-5) != length {
+		return nil, fmt.Errorf("gRPC message length %d does not match %d bytes received", length, len(data)-5)
+	}
+	return data[5:], nil
+}
+
+// grpcHealthPath is the method of the standard grpc.health.v1 protocol
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// grpcHealthServing is the status of a backend able to serve requests
+const grpcHealthServing = 1
+
+// grpcHealthStatusNames names the grpc.health.v1 serving states
+var grpcHealthStatusNames = map[uint64]string{
+	0:                 "UNKNOWN",
+	grpcHealthServing: "SERVING",
+	2:                 "NOT_SERVING",
+	3:                 "SERVICE_UNKNOWN",
+}
+
+// encodeHealthCheckRequest encodes a grpc.health.v1.HealthCheckRequest
+// protobuf message. Its only field is the service name (field 1, string).
+func encodeHealthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	message := []byte{1<<3 | 2}
+	message = binary.AppendUvarint(message, uint64(len(service)))
+	return append(message, service...)
+}
+
+// decodeHealthCheckResponse returns the status (field 1, enum) of a
+// grpc.health.v1.HealthCheckResponse protobuf message, skipping unknown
+// fields. A missing status is UNKNOWN.
+func decodeHealthCheckResponse(message []byte) (uint64, error) {
+	var status uint64
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errors.New("malformed health check response")
+		}
+		message = message[n:]
+
+		switch field, wireType := key>>3, key&7; wireType {
+		case 0: // varint
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[n:]
+			if field == 1 {
+				status = value
+			}
+		case 1: // 64-bit
+			if len(message) < 8 {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[8:]
+		case 2: // length-delimited
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[n+int(length):]
+		case 5: // 32-bit
+			if len(message) < 4 {
+				return 0, errors.New("malformed health check response")
+			}
+			message = message[4:]
+		default:
+			return 0, fmt.Errorf("unsupported protobuf wire type %d in health check response", wireType)
+		}
+	}
+	return status, nil
+}
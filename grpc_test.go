@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newGRPCBackend returns a cleartext HTTP/2 backend implementing a greeter
+// service and the grpc.health.v1 protocol. Health checks for the "down"
+// service report NOT_SERVING.
+func newGRPCBackend(t *testing.T) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		message, err := readGRPCFrame(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var reply []byte
+		switch r.URL.Path {
+		case grpcHealthPath:
+			status := byte(grpcHealthServing)
+			if bytes.HasSuffix(message, []byte("down")) {
+				status = 2
+			}
+			reply = []byte{1 << 3, status}
+		case "/helloworld.Greeter/SayHello":
+			reply = append([]byte("hello "), message...)
+		default:
+			writeGRPCError(w, grpcUnimplemented, "unknown method")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write(grpcFrame(reply))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"X-Backend-Trailer", "done")
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newGRPCClient returns a client speaking cleartext HTTP/2
+func newGRPCClient(t *testing.T) *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: protocols}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
+
+// callGRPC sends message to the gRPC method at url and returns the response
+// with its body read, so its trailers are available
+func callGRPC(t *testing.T, client *http.Client, url string, message []byte) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(grpcFrame(message)))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return &http.Response{Header: http.Header{}, Trailer: http.Header{}}, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, body
+}
+
+func TestIsGRPCRequest(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/grpc":         true,
+		"application/grpc+proto":   true,
+		"application/grpc-web":     false,
+		"application/json":         false,
+		"":                         false,
+		"application/grpc;charset": true,
+	} {
+		req := httptest.NewRequest("POST", "/pkg.Service/Method", nil)
+		req.Header.Set("Content-Type", contentType)
+		assert.Equal(t, want, isGRPCRequest(req), contentType)
+	}
+}
+
+func TestProxy_GRPC(t *testing.T) {
+	backend := newGRPCBackend(t)
+	backends := []BackendConfig{{URL: backend.URL, Protocol: BackendProtocolH2C}}
+	enabled := true
+
+	config := newTestReloadConfig(backend.URL)
+	config.Routes = []RouteConfig{
+		{Name: "greeter", GRPCService: "helloworld.Greeter", Backends: backends},
+		{Name: "limited", GRPCService: "helloworld.Limited", Backends: backends,
+			RateLimit: &RouteRateLimitConfig{Enabled: &enabled, RequestsPerMinute: 1, BurstSize: 1}},
+	}
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	addr := startHTTP2Server(t, proxy, HTTP2Config{H2C: true}, nil)
+	client := newGRPCClient(t)
+
+	// Messages and trailers pass through
+	resp, body := callGRPC(t, client, "http://"+addr+"/helloworld.Greeter/SayHello", []byte("world"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	message, err := readGRPCFrame(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(message))
+	assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+	assert.Equal(t, "done", resp.Trailer.Get("X-Backend-Trailer"))
+
+	// Unrouted services are unimplemented
+	resp, body = callGRPC(t, client, "http://"+addr+"/helloworld.Other/SayHello", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "12", resp.Header.Get("Grpc-Status"))
+	assert.Equal(t, "No route matches the request", resp.Header.Get("Grpc-Message"))
+	assert.Empty(t, body)
+
+	// Rate limiting reports RESOURCE_EXHAUSTED
+	callGRPC(t, client, "http://"+addr+"/helloworld.Limited/SayHello", nil)
+	resp, _ = callGRPC(t, client, "http://"+addr+"/helloworld.Limited/SayHello", nil)
+	assert.Equal(t, "8", resp.Header.Get("Grpc-Status"))
+}
+
+func TestWriteErrorResponse_GRPC(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusGatewayTimeout, "4"},
+		{http.StatusTooManyRequests, "8"},
+		{http.StatusServiceUnavailable, "14"},
+		{http.StatusBadGateway, "14"},
+		{http.StatusForbidden, "7"},
+		{http.StatusNotFound, "12"},
+		{http.StatusInternalServerError, "13"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/pkg.Service/Method", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		rr := httptest.NewRecorder()
+		writeErrorResponse(rr, req, tt.status, "error", "100% failed\n")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/grpc", rr.Header().Get("Content-Type"))
+		assert.Equal(t, tt.code, rr.Header().Get("Grpc-Status"), tt.status)
+		assert.Equal(t, "100%25 failed%0A", rr.Header().Get("Grpc-Message"))
+		assert.Empty(t, rr.Body.String())
+	}
+
+	// Other clients still get JSON
+	rr := httptest.NewRecorder()
+	writeErrorResponse(rr, httptest.NewRequest("GET", "/", nil), http.StatusServiceUnavailable, "circuit_open", "unavailable")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "circuit_open")
+}
+
+func TestTimeoutMiddleware_GRPC(t *testing.T) {
+	handler := timeoutMiddleware(20*time.Millisecond, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	req := httptest.NewRequest("POST", "/pkg.Service/Slow", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "4", rr.Header().Get("Grpc-Status"))
+	assert.Equal(t, "Request timed out", rr.Header().Get("Grpc-Message"))
+}
+
+func TestRouter_GRPCMatchers(t *testing.T) {
+	newRoute := func(config RouteConfig) *Route {
+		route, err := NewRoute(config, nil)
+		assert.NoError(t, err)
+		return route
+	}
+	router := NewRouter([]*Route{
+		newRoute(RouteConfig{Name: "service", GRPCService: "users.v1.Users"}),
+		newRoute(RouteConfig{Name: "method", GRPCService: "users.v1.Users", GRPCMethod: "Delete"}),
+		newRoute(RouteConfig{Name: "fallback", PathPrefix: "/"}),
+	})
+
+	match := func(path, contentType string) string {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Content-Type", contentType)
+		return router.Match(req).Name
+	}
+	assert.Equal(t, "method", match("/users.v1.Users/Delete", "application/grpc"))
+	assert.Equal(t, "service", match("/users.v1.Users/Get", "application/grpc+proto"))
+	assert.Equal(t, "fallback", match("/users.v1.UsersAdmin/Get", "application/grpc"))
+	assert.Equal(t, "fallback", match("/users.v1.Users/Get", "application/json"), "only gRPC calls match")
+}
+
+func TestHealthChecker_GRPC(t *testing.T) {
+	backend := newGRPCBackend(t)
+	h2c, err := NewBackend(BackendConfig{URL: backend.URL, Protocol: BackendProtocolH2C})
+	assert.NoError(t, err)
+	http1, err := NewBackend(BackendConfig{URL: backend.URL})
+	assert.NoError(t, err)
+
+	config := HealthCheckConfig{Type: HealthCheckGRPC, Timeout: time.Second}
+	assert.NoError(t, NewHealthChecker(config, nil).probe(h2c))
+	assert.Error(t, NewHealthChecker(config, nil).probe(http1), "gRPC needs HTTP/2")
+
+	config.GRPCService = "down"
+	assert.ErrorContains(t, NewHealthChecker(config, nil).probe(h2c), "health status NOT_SERVING")
+
+	// The checker takes a backend reporting NOT_SERVING out of rotation
+	checker := NewHealthChecker(config, []*Backend{h2c})
+	checker.CheckAll()
+	assert.False(t, h2c.Healthy())
+}
+
+func TestHealthCheckProtobuf(t *testing.T) {
+	assert.Nil(t, encodeHealthCheckRequest(""))
+	assert.Equal(t, []byte{0x0a, 0x05, 'u', 's', 'e', 'r', 's'}, encodeHealthCheckRequest("users"))
+
+	// Unknown fields are skipped
+	status, err := decodeHealthCheckResponse([]byte{0x12, 0x02, 'h', 'i', 0x08, 0x01, 0x18, 0x96, 0x01})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(grpcHealthServing), status)
+
+	status, err = decodeHealthCheckResponse(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), status)
+
+	_, err = decodeHealthCheckResponse([]byte{0x12, 0x05, 'h'})
+	assert.Error(t, err)
+
+	message, err := readGRPCFrame(grpcFrame([]byte("abc")))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(message))
+	_, err = readGRPCFrame([]byte{1, 0, 0, 0, 0})
+	assert.ErrorContains(t, err, "compressed")
+	_, err = readGRPCFrame([]byte{0, 0, 0, 0, 9, 'a'})
+	assert.Error(t, err)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health check probe types
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckGRPC = "grpc" // grpc.health.v1 protocol
+)
+
+// maxHealthCheckBody limits how much of a health check response is read
+const maxHealthCheckBody = 64 * 1024
+
+// HealthCheckConfig holds the settings for active health checking
+type HealthCheckConfig struct {
+	Type           string
+	Path           string
+	Interval       time.Duration
+	Timeout        time.Duration
+	ExpectedStatus int    // 0 accepts any 2xx status
+	ExpectedBody   string // substring the body must contain, if set
+	GRPCService    string // service checked by gRPC probes; empty checks the whole server
+	Rise           int    // consecutive successes before marking healthy
+	Fall           int    // consecutive failures before marking unhealthy
+}
+
+// healthState tracks consecutive probe results for a backend
+type healthState struct {
+	successes int
+	failures  int
+}
+
+// HealthChecker periodically probes backends and takes unhealthy ones out of rotation
+type HealthChecker struct {
+	config   HealthCheckConfig
+	backends []*Backend
+	states   map[*Backend]*healthState
+	mu       sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewHealthChecker creates a health checker for the given backends
+func NewHealthChecker(config HealthCheckConfig, backends []*Backend) *HealthChecker {
+	if config.Interval <= 0 {
+		config.Interval = 10 * time.Second
+	}
+	if config.Rise <= 0 {
+		config.Rise = 1
+	}
+	if config.Fall <= 0 {
+		config.Fall = 1
+	}
+
+	states := make(map[*Backend]*healthState, len(backends))
+	for _, backend := range backends {
+		states[backend] = &healthState{}
+	}
+
+	return &HealthChecker{
+		config:   config,
+		backends: backends,
+		states:   states,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs an immediate check and then probes on every interval until Stop is called
+func (hc *HealthChecker) Start() {
+	go func() {
+		defer close(hc.done)
+
+		ticker := time.NewTicker(hc.config.Interval)
+		defer ticker.Stop()
+
+		hc.CheckAll()
+		for {
+			select {
+			case <-ticker.C:
+				hc.CheckAll()
+			case <-hc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends periodic probing and waits for the checker to exit
+func (hc *HealthChecker) Stop() {
+	close(hc.stop)
+	<-hc.done
+}
+
+// CheckAll probes every backend concurrently and updates their health
+func (hc *HealthChecker) CheckAll() {
+	var wg sync.WaitGroup
+	for _, backend := range hc.backends {
+		wg.Add(1)
+		go func(backend *Backend) {
+			defer wg.Done()
+			hc.record(backend, hc.probe(backend))
+		}(backend)
+	}
+	wg.Wait()
+}
+
+// record applies a probe result, flipping the backend's state once the
+// rise or fall threshold is reached
+func (hc *HealthChecker) record(backend *Backend, err error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	state := hc.states[backend]
+	if err == nil {
+		state.successes++
+		state.failures = 0
+		if !backend.Healthy() && state.successes >= hc.config.Rise {
+			backend.setHealthy(true)
+			slog.Info("backend healthy again", "backend", backend.URL.Host)
+		}
+		return
+	}
+
+	state.failures++
+	state.successes = 0
+	if backend.Healthy() && state.failures >= hc.config.Fall {
+		backend.setHealthy(false)
+		slog.Warn("backend unhealthy", "backend", backend.URL.Host, "error", err)
+	}
+}
+
+// probe runs a single health check against a backend
+func (hc *HealthChecker) probe(backend *Backend) error {
+	switch hc.config.Type {
+	case HealthCheckTCP:
+		return hc.probeTCP(backend)
+	case HealthCheckGRPC:
+		return hc.probeGRPC(backend)
+	}
+	return hc.probeHTTP(backend)
+}
+
+// probeHTTP requests the health check path and verifies status and body
+func (hc *HealthChecker) probeHTTP(backend *Backend) error {
+	target := *backend.URL
+	target.Path = singleJoiningSlash(target.Path, hc.config.Path)
+	target.RawPath = ""
+	target.RawQuery = ""
+
+	client := &http.Client{
+		Transport: backend.transport,
+		Timeout:   hc.config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(target.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if hc.config.ExpectedStatus != 0 {
+		if resp.StatusCode != hc.config.ExpectedStatus {
+			return fmt.Errorf("unexpected status %d, want %d", resp.StatusCode, hc.config.ExpectedStatus)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if hc.config.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), hc.config.ExpectedBody) {
+			return fmt.Errorf("response body does not contain %q", hc.config.ExpectedBody)
+		}
+	}
+
+	return nil
+}
+
+// probeGRPC calls the backend's grpc.health.v1 Check method and requires
+// the service to be SERVING
+func (hc *HealthChecker) probeGRPC(backend *Backend) error {
+	target := *backend.URL
+	target.Path = singleJoiningSlash(target.Path, grpcHealthPath)
+	target.RawPath = ""
+	target.RawQuery = ""
+
+	req, err := http.NewRequest(http.MethodPost, target.String(), bytes.NewReader(grpcFrame(encodeHealthCheckRequest(hc.config.GRPCService))))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	client := &http.Client{Transport: backend.transport, Timeout: hc.config.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+	if err != nil {
+		return err
+	}
+	// Failed calls may carry their status in the headers instead of trailers
+	trailer := resp.Trailer
+	if trailer.Get("Grpc-Status") == "" {
+		trailer = resp.Header
+	}
+	switch status := trailer.Get("Grpc-Status"); status {
+	case "0":
+	case "":
+		return fmt.Errorf("response has no grpc-status")
+	default:
+		return fmt.Errorf("grpc-status %s: %s", status, trailer.Get("Grpc-Message"))
+	}
+
+	message, err := readGRPCFrame(body)
+	if err != nil {
+		return err
+	}
+	serving, err := decodeHealthCheckResponse(message)
+	if err != nil {
+		return err
+	}
+	if serving != grpcHealthServing {
+		return fmt.Errorf("health status %s", grpcHealthStatusNames[serving])
+	}
+	return nil
+}
+
+// probeTCP checks that a TCP connection to the backend can be opened
+func (hc *HealthChecker) probeTCP(backend *Backend) error {
+	conn, err := net.DialTimeout("tcp", backendAddress(backend.URL), hc.config.Timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHealthCheckTestConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Type:     HealthCheckHTTP,
+		Path:     "/health",
+		Interval: time.Hour,
+		Timeout:  time.Second,
+		Rise:     2,
+		Fall:     2,
+	}
+}
+
+func TestHealthChecker_HTTPProbe(t *testing.T) {
+	var status int32 = http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		w.Write([]byte("status: ok"))
+	}))
+	defer server.Close()
+
+	backend, _ := NewBackend(BackendConfig{URL: server.URL})
+	hc := NewHealthChecker(newHealthCheckTestConfig(), []*Backend{backend})
+
+	assert.NoError(t, hc.probe(backend))
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	assert.Error(t, hc.probe(backend))
+}
+
+func TestHealthChecker_ExpectedStatusAndBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		w.Write([]byte("degraded"))
+	}))
+	defer server.Close()
+
+	backend, _ := NewBackend(BackendConfig{URL: server.URL})
+
+	config := newHealthCheckTestConfig()
+	config.ExpectedStatus = http.StatusOK
+	assert.Error(t, NewHealthChecker(config, []*Backend{backend}).probe(backend))
+
+	config.ExpectedStatus = http.StatusNoContent
+	assert.NoError(t, NewHealthChecker(config, []*Backend{backend}).probe(backend))
+
+	config.ExpectedStatus = 0
+	config.ExpectedBody = "ok"
+	hc := NewHealthChecker(config, []*Backend{backend})
+	err := hc.probe(backend)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "does not contain")
+}
+
+func TestHealthChecker_TCPProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+
+	backend, _ := NewBackend(BackendConfig{URL: "http://" + addr})
+	config := newHealthCheckTestConfig()
+	config.Type = HealthCheckTCP
+	hc := NewHealthChecker(config, []*Backend{backend})
+
+	assert.NoError(t, hc.probe(backend))
+
+	listener.Close()
+	assert.Error(t, hc.probe(backend))
+}
+
+func TestHealthChecker_RiseAndFallThresholds(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	backend, _ := NewBackend(BackendConfig{URL: server.URL})
+	hc := NewHealthChecker(newHealthCheckTestConfig(), []*Backend{backend})
+
+	healthy.Store(false)
+	hc.CheckAll()
+	assert.True(t, backend.Healthy(), "one failure is below the fall threshold")
+	hc.CheckAll()
+	assert.False(t, backend.Healthy(), "two failures reach the fall threshold")
+	assert.Contains(t, helper.GetLogs(), "backend unhealthy")
+
+	healthy.Store(true)
+	hc.CheckAll()
+	assert.False(t, backend.Healthy(), "one success is below the rise threshold")
+	hc.CheckAll()
+	assert.True(t, backend.Healthy(), "two successes reach the rise threshold")
+	assert.Contains(t, helper.GetLogs(), "backend healthy again")
+}
+
+func TestHealthChecker_StartAndStop(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend, _ := NewBackend(BackendConfig{URL: "http://127.0.0.1:0"})
+	config := newHealthCheckTestConfig()
+	config.Fall = 1
+	hc := NewHealthChecker(config, []*Backend{backend})
+
+	// Start runs a check immediately
+	hc.Start()
+	assert.Eventually(t, func() bool { return !backend.Healthy() }, time.Second, 10*time.Millisecond)
+	hc.Stop()
+}
+
+func TestBackendPool_SkipsUnhealthyBackends(t *testing.T) {
+	var hitsA, hitsB int32
+	backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hitsA, 1)
+	}))
+	defer backendA.Close()
+	backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hitsB, 1)
+	}))
+	defer backendB.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: backendA.URL}, {URL: backendB.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	pool.Backends()[0].setHealthy(false)
+
+	for i := 0; i < 4; i++ {
+		pool.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hitsA))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hitsB))
+
+	// With every backend out of rotation the pool fails fast
+	pool.Backends()[1].setHealthy(false)
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "no_backend_available")
+}
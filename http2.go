@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Protocols the proxy speaks to a backend
+const (
+	BackendProtocolAuto  = "auto"  // HTTP/2 when negotiated via TLS ALPN, HTTP/1.1 otherwise
+	BackendProtocolHTTP1 = "http1" // HTTP/1.1 only
+	BackendProtocolHTTP2 = "http2" // HTTP/2 over TLS only
+	BackendProtocolH2C   = "h2c"   // cleartext HTTP/2 with prior knowledge
+)
+
+// Flow-control window limits allowed by HTTP/2 (RFC 9113, section 6.9)
+const (
+	http2MinWindow = 65535
+	http2MaxWindow = 1<<31 - 1
+)
+
+// HTTP2Config holds the HTTP/2 settings for client connections
+type HTTP2Config struct {
+	Enabled              bool // offer HTTP/2 to TLS clients via ALPN
+	H2C                  bool // accept cleartext HTTP/2 with prior knowledge
+	MaxConcurrentStreams int  // streams a client may have open on one connection
+	MaxConnectionWindow  int  // bytes a client may send on a connection before the proxy reads them
+	MaxStreamWindow      int  // bytes a client may send on one stream before the proxy reads them
+}
+
+// configureHTTP2 sets the protocols a client-facing server accepts and
+// its HTTP/2 limits. HTTP/1.1 is always accepted.
+func configureHTTP2(server *http.Server, config HTTP2Config) {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(config.Enabled)
+	protocols.SetUnencryptedHTTP2(config.H2C)
+	server.Protocols = protocols
+	server.HTTP2 = &http.HTTP2Config{
+		MaxConcurrentStreams:          config.MaxConcurrentStreams,
+		MaxReceiveBufferPerConnection: config.MaxConnectionWindow,
+		MaxReceiveBufferPerStream:     config.MaxStreamWindow,
+	}
+}
+
+// backendProtocols returns the protocols a backend transport may use, or
+// nil to negotiate them as http.DefaultTransport does
+func backendProtocols(protocol string) *http.Protocols {
+	protocols := new(http.Protocols)
+	switch protocol {
+	case BackendProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case BackendProtocolHTTP2:
+		protocols.SetHTTP2(true)
+	case BackendProtocolH2C:
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil
+	}
+	return protocols
+}
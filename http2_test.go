@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// protoHandler responds with the HTTP version the request arrived over
+var protoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(r.Proto))
+})
+
+// startHTTP2Server serves handler configured with config, over TLS when
+// tlsConfig is set, and returns its address
+func startHTTP2Server(t *testing.T, handler http.Handler, config HTTP2Config, tlsConfig *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+	configureHTTP2(server, config)
+	go func() {
+		if tlsConfig != nil {
+			server.ServeTLS(listener, "", "")
+		} else {
+			server.Serve(listener)
+		}
+	}()
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// getProto requests url with client and returns the response body
+func getProto(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestConfigureHTTP2(t *testing.T) {
+	server := &http.Server{}
+	configureHTTP2(server, HTTP2Config{Enabled: true, MaxConcurrentStreams: 100, MaxConnectionWindow: 1 << 20, MaxStreamWindow: 1 << 18})
+	assert.True(t, server.Protocols.HTTP1())
+	assert.True(t, server.Protocols.HTTP2())
+	assert.False(t, server.Protocols.UnencryptedHTTP2())
+	assert.Equal(t, 100, server.HTTP2.MaxConcurrentStreams)
+	assert.Equal(t, 1<<20, server.HTTP2.MaxReceiveBufferPerConnection)
+	assert.Equal(t, 1<<18, server.HTTP2.MaxReceiveBufferPerStream)
+}
+
+func TestConfigureHTTP2_TLSClients(t *testing.T) {
+	dir := t.TempDir()
+	cert := writeTestCertificate(t, dir, "proxy", "localhost")
+	store, err := newCertStore([]TLSCertificateConfig{cert})
+	assert.NoError(t, err)
+	roots, err := loadCertPool(cert.CertFile)
+	assert.NoError(t, err)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: roots, ServerName: "localhost"},
+		ForceAttemptHTTP2: true,
+	}}
+	defer client.CloseIdleConnections()
+
+	for _, enabled := range []bool{true, false} {
+		tlsConfig, err := newServerTLSConfig(TLSConfig{MinVersion: tls.VersionTLS12}, store.GetCertificate)
+		assert.NoError(t, err)
+		addr := startHTTP2Server(t, protoHandler, HTTP2Config{Enabled: enabled}, tlsConfig)
+
+		want := "HTTP/1.1"
+		if enabled {
+			want = "HTTP/2.0"
+		}
+		assert.Equal(t, want, getProto(t, client, "https://"+addr))
+	}
+}
+
+func TestConfigureHTTP2_H2C(t *testing.T) {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2cClient := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	defer h2cClient.CloseIdleConnections()
+
+	addr := startHTTP2Server(t, protoHandler, HTTP2Config{H2C: true}, nil)
+	assert.Equal(t, "HTTP/2.0", getProto(t, h2cClient, "http://"+addr))
+	assert.Equal(t, "HTTP/1.1", getProto(t, http.DefaultClient, "http://"+addr), "HTTP/1.1 clients are still served")
+
+	addr = startHTTP2Server(t, protoHandler, HTTP2Config{}, nil)
+	_, err := h2cClient.Get("http://" + addr)
+	assert.Error(t, err)
+}
+
+func TestBackendPool_Protocols(t *testing.T) {
+	// A cleartext backend accepting both HTTP/1.1 and h2c
+	plain := httptest.NewUnstartedServer(protoHandler)
+	plain.Config.Protocols = new(http.Protocols)
+	plain.Config.Protocols.SetHTTP1(true)
+	plain.Config.Protocols.SetUnencryptedHTTP2(true)
+	plain.Start()
+	defer plain.Close()
+
+	// A TLS backend offering both HTTP/1.1 and HTTP/2
+	cert := writeTestCertificate(t, t.TempDir(), "backend", "backend.internal")
+	store, err := newCertStore([]TLSCertificateConfig{cert})
+	assert.NoError(t, err)
+	secure := httptest.NewUnstartedServer(protoHandler)
+	secure.TLS = &tls.Config{GetCertificate: store.GetCertificate}
+	secure.EnableHTTP2 = true
+	secure.StartTLS()
+	defer secure.Close()
+	backendTLS := &BackendTLSConfig{CAFile: cert.CertFile, ServerName: "backend.internal"}
+
+	tests := []struct {
+		name    string
+		backend BackendConfig
+		want    string
+	}{
+		{"cleartext default", BackendConfig{URL: plain.URL}, "HTTP/1.1"},
+		{"h2c", BackendConfig{URL: plain.URL, Protocol: BackendProtocolH2C}, "HTTP/2.0"},
+		{"tls auto", BackendConfig{URL: secure.URL, TLS: backendTLS}, "HTTP/2.0"},
+		{"tls http1", BackendConfig{URL: secure.URL, TLS: backendTLS, Protocol: BackendProtocolHTTP1}, "HTTP/1.1"},
+		{"tls http2", BackendConfig{URL: secure.URL, TLS: backendTLS, Protocol: BackendProtocolHTTP2}, "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := NewBackendPool([]BackendConfig{tt.backend}, StrategyRoundRobin, "")
+			assert.NoError(t, err)
+			defer pool.closeIdleConnections()
+
+			rr := httptest.NewRecorder()
+			pool.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.want, rr.Body.String())
+		})
+	}
+}
+
+func TestProxy_H2CEndToEnd(t *testing.T) {
+	backend := httptest.NewUnstartedServer(protoHandler)
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	config := newTestReloadConfig("")
+	config.Backends = []BackendConfig{{URL: backend.URL, Protocol: BackendProtocolH2C}}
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	addr := startHTTP2Server(t, proxy, HTTP2Config{H2C: true}, nil)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	defer client.CloseIdleConnections()
+	assert.Equal(t, "HTTP/2.0", getProto(t, client, "http://"+addr))
+}
+
+func TestBackendProtocols(t *testing.T) {
+	assert.Nil(t, backendProtocols(""))
+	assert.Nil(t, backendProtocols(BackendProtocolAuto))
+	assert.True(t, backendProtocols(BackendProtocolH2C).UnencryptedHTTP2())
+	assert.False(t, backendProtocols(BackendProtocolH2C).HTTP1())
+	assert.True(t, backendProtocols(BackendProtocolHTTP2).HTTP2())
+	assert.True(t, backendProtocols(BackendProtocolHTTP1).HTTP1())
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel is the level of the logger installed by setupLogging. It is
+// shared so a configuration reload can change it in place.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel converts a log_level value to a slog.Level, defaulting to info
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// newLogger creates a logger writing text or JSON lines to w
+func newLogger(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// setupLogging installs the default logger described by config. Output from
+// the standard log package is routed through it as well.
+func setupLogging(config *Config) {
+	logLevel.Set(parseLogLevel(config.LogLevel))
+	slog.SetDefault(newLogger(os.Stderr, config.LogFormat, logLevel))
+}
+
+// requestAttrs returns the fields that identify a request in log lines
+func requestAttrs(r *http.Request) []any {
+	info := getRequestInfo(r.Context()).snapshot()
+	attrs := []any{
+		"request_id", info.ID,
+		"client", getClientKey(r),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"route", info.Route,
+		"backend", info.Backend,
+	}
+	if id := traceID(r); id != "" {
+		attrs = append(attrs, "trace_id", id)
+	}
+	return attrs
+}
+
+// loggingResponseWriter wraps http.ResponseWriter to capture status code
+// and the number of body bytes written
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int64
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	lrw.statusCode = code
+	lrw.ResponseWriter.WriteHeader(code)
+}
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends buffered data to the client
+func (lrw *loggingResponseWriter) Flush() {
+	flush(lrw.ResponseWriter)
+}
+
+// Hijack hands the connection to the handler, recording the protocol switch
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(lrw.ResponseWriter)
+	if err == nil {
+		lrw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// loggingMiddleware logs HTTP requests and responses. Server errors are
+// logged at error level, everything else at info.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = withRequestInfo(r)
+		start := time.Now()
+
+		// Wrap the ResponseWriter to capture status code
+		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		// Call the next handler
+		next.ServeHTTP(lrw, r)
+
+		// Log the request
+		level := slog.LevelInfo
+		if lrw.statusCode >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := append(requestAttrs(r), "status", lrw.statusCode, "duration", time.Since(start))
+		slog.Log(r.Context(), level, "request", attrs...)
+	})
+}
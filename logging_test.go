@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	assert.Equal(t, slog.LevelDebug, parseLogLevel("debug"))
+	assert.Equal(t, slog.LevelInfo, parseLogLevel("info"))
+	assert.Equal(t, slog.LevelWarn, parseLogLevel("WARN"))
+	assert.Equal(t, slog.LevelError, parseLogLevel("error"))
+	assert.Equal(t, slog.LevelInfo, parseLogLevel(""))
+}
+
+func TestNewLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, LogFormatJSON, slog.LevelInfo)
+
+	logger.Info("request", "route", "api", "status", 200)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "INFO", entry["level"])
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "api", entry["route"])
+	assert.Equal(t, float64(200), entry["status"])
+}
+
+func TestNewLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, LogFormatText, slog.LevelInfo)
+
+	logger.Info("request", "route", "api")
+
+	assert.Contains(t, buf.String(), "level=INFO msg=request route=api")
+}
+
+func TestNewLogger_HonorsLevel(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelWarn)
+	logger := newLogger(&buf, LogFormatText, level)
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown")
+
+	buf.Reset()
+	level.Set(slog.LevelDebug)
+	logger.Debug("now shown")
+	assert.Contains(t, buf.String(), "now shown")
+}
+
+func TestLoggingMiddleware_LogsRequestFields(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	handler := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := getRequestInfo(r.Context())
+		info.setRoute("api")
+		info.setBackend("backend:80")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := helper.GetLogs()
+	assert.Contains(t, logs, "ERROR request request_id=")
+	assert.Contains(t, logs, "client=203.0.113.7 method=GET path=/users route=api backend=backend:80 status=502 duration=")
+}
+
+func TestErrorHandlingMiddleware_LogsPanicWithRequestFields(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	handler := errorHandlingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		getRequestInfo(r.Context()).setRoute("api")
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/users", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	logs := helper.GetLogs()
+	assert.Contains(t, logs, "ERROR panic recovered request_id=")
+	assert.Contains(t, logs, "route=api")
+	assert.Contains(t, logs, "status=500")
+	assert.Contains(t, logs, "error=boom")
+}
@@ -2,95 +2,182 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
-)
-
-// loggingResponseWriter wraps http.ResponseWriter to capture status code
-type loggingResponseWriter struct {
-	http.ResponseWriter
-	statusCode int
-}
-
-func (lrw *loggingResponseWriter) WriteHeader(code int) {
-	lrw.statusCode = code
-	lrw.ResponseWriter.WriteHeader(code)
-}
-
-// loggingMiddleware logs HTTP requests and responses
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
 
-		// Wrap the ResponseWriter to capture status code
-		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-
-		// Call the next handler
-		next.ServeHTTP(lrw, r)
-
-		// Log the request
-		duration := time.Since(start)
-		log.Printf("%s %s %d %v", r.Method, r.URL.Path, lrw.statusCode, duration)
-	})
-}
+	"golang.org/x/crypto/acme/autocert"
+)
 
+// reverseProxy creates a proxy handler for a single backend URL
 func reverseProxy(target string) http.Handler {
-	targetURL, err := url.Parse(target)
+	pool, err := NewBackendPool([]BackendConfig{{URL: target, Weight: 1}}, StrategyRoundRobin, "")
 	if err != nil {
-		fmt.Println("Error parsing URL: ", err)
+		slog.Error("invalid backend URL", "backend", target, "error", err)
 		return nil
 	}
-	return httputil.NewSingleHostReverseProxy(targetURL)
+	return pool
 }
 
 func main() {
 	config, err := LoadConfig()
 	if err != nil {
-		fmt.Printf("Failed to load configuration: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
 		os.Exit(1)
 	}
+	if CheckConfigOnly() {
+		fmt.Println("Configuration is valid")
+		return
+	}
 
-	fmt.Printf("Starting proxy server on port %d, backend: %s\n", config.Port, config.Backend)
+	setupLogging(config)
 
-	// Create cache if enabled
-	var cache *Cache
-	if config.CacheEnabled {
-		cache = NewCache(config.CacheSize, config.CacheTTL)
-		fmt.Printf("Cache enabled: size=%d, ttl=%ds\n", config.CacheSize, config.CacheTTL)
+	routes := config.RouteList()
+	slog.Info("starting proxy server", "port", config.Port, "routes", len(routes), "log_level", config.LogLevel)
+
+	// Collect metrics if enabled
+	var metrics *Metrics
+	if config.MetricsEnabled {
+		metrics = NewMetrics()
+		slog.Info("metrics enabled", "path", config.MetricsPath)
 	}
 
-	// Create rate limiter if enabled
-	var rateLimiter *RateLimiter
-	if config.RateLimitEnabled {
-		rateLimiter = NewRateLimiter(config.RateLimitRPM, config.RateLimitBurst)
-		fmt.Printf("Rate limiting enabled: %d RPM, burst=%d\n", config.RateLimitRPM, config.RateLimitBurst)
+	proxy, err := NewProxy(config, metrics)
+	if err != nil {
+		slog.Error("failed to create proxy", "error", err)
+		os.Exit(1)
 	}
+	proxy.Start()
+
+	// Reload the configuration on SIGHUP and when config files change
+	reloader := NewReloader(proxy, ReloadConfig, metrics)
+	defer reloader.Stop()
+	reloader.WatchSignals()
+	reloader.WatchFiles(ConfigFiles(), time.Duration(config.ConfigReloadInterval)*time.Second)
 
-	// Build middleware chain
-	handler := reverseProxy(config.Backend)
-	handler = loggingMiddleware(handler)
-	if cache != nil {
-		handler = cachingMiddleware(cache, handler)
+	if config.OutlierDetectionEnabled {
+		slog.Info("outlier detection enabled", "consecutive_failures", config.OutlierConsecutiveFailures)
 	}
-	if rateLimiter != nil {
-		handler = rateLimitMiddleware(rateLimiter, handler)
+	if config.CircuitBreakerEnabled {
+		slog.Info("circuit breakers enabled", "consecutive_failures", config.CircuitBreakerConsecutiveFailures,
+			"error_rate_percent", config.CircuitBreakerErrorRate)
+	}
+	if config.RetryEnabled {
+		slog.Info("retries enabled", "max_attempts", config.RetryMaxAttempts, "budget_percent", config.RetryBudgetPercent)
+	}
+	if config.HealthCheckEnabled {
+		slog.Info("health checks enabled", "type", config.HealthCheckType, "interval_seconds", config.HealthCheckInterval)
+	}
+	if config.CacheEnabled {
+		slog.Info("cache enabled", "size", config.CacheSize, "ttl_seconds", config.CacheTTL)
+	}
+	if config.RateLimitEnabled {
+		slog.Info("rate limiting enabled", "requests_per_minute", config.RateLimitRPM, "burst_size", config.RateLimitBurst)
 	}
-
-	// Add error handling and timeout middleware
-	handler = errorHandlingMiddleware(handler)
-	handler = timeoutMiddleware(time.Duration(config.RequestTimeout)*time.Second, handler)
 
 	// Create HTTP server
+	errorLog := slog.NewLogLogger(slog.Default().Handler(), slog.LevelError)
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", config.Port),
-		Handler: handler,
+		Addr:     fmt.Sprintf(":%d", config.Port),
+		Handler:  reloader,
+		ErrorLog: errorLog,
+	}
+	configureHTTP2(server, config.HTTP2())
+
+	// Terminate TLS if enabled, reloading certificates when their files
+	// change and obtaining them via ACME if configured
+	var acmeManager *autocert.Manager
+	if config.TLSEnabled {
+		tlsConfig := config.TLS()
+		var certs *certStore
+		var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		if len(tlsConfig.Certificates) > 0 {
+			certs, err = newCertStore(tlsConfig.Certificates)
+			if err != nil {
+				slog.Error("failed to load TLS certificates", "error", err)
+				os.Exit(1)
+			}
+			defer certs.Stop()
+			certs.Watch(tlsConfig.ReloadInterval)
+			getCertificate = certs.GetCertificate
+		}
+		if config.ACMEEnabled {
+			acmeManager, err = newACMEManager(config.ACME())
+			if err != nil {
+				slog.Error("invalid ACME configuration", "error", err)
+				os.Exit(1)
+			}
+			getCertificate = acmeGetCertificate(acmeManager, certs)
+			slog.Info("ACME enabled", "directory", config.ACMEDirectoryURL, "domains", config.ACMEDomains)
+		}
+
+		server.TLSConfig, err = newServerTLSConfig(tlsConfig, getCertificate)
+		if err != nil {
+			slog.Error("invalid TLS configuration", "error", err)
+			os.Exit(1)
+		}
+		if acmeManager != nil {
+			withACMEChallenge(server.TLSConfig)
+		}
+		slog.Info("TLS enabled", "certificates", len(tlsConfig.Certificates), "min_version", config.TLSMinVersion,
+			"client_auth", config.TLSClientAuth, "http2", config.HTTP2Enabled)
+	}
+
+	if config.H2CEnabled {
+		slog.Info("h2c enabled", "max_concurrent_streams", config.HTTP2MaxConcurrentStreams)
+	}
+
+	// Create the plain HTTP server if enabled, redirecting to HTTPS and
+	// answering ACME HTTP-01 challenges
+	var httpHandler http.Handler
+	if config.TLSEnabled && config.HTTPRedirectEnabled {
+		httpHandler = httpsRedirectHandler(config.Port)
+	}
+	if acmeManager != nil && config.ACMEHTTPChallenge {
+		fallback := httpHandler
+		if fallback == nil {
+			fallback = http.NotFoundHandler()
+		}
+		httpHandler = acmeManager.HTTPHandler(fallback)
+	}
+	var httpServer *http.Server
+	if httpHandler != nil {
+		httpServer = &http.Server{
+			Addr:     fmt.Sprintf(":%d", config.HTTPRedirectPort),
+			Handler:  httpHandler,
+			ErrorLog: errorLog,
+		}
+		go func() {
+			slog.Info("HTTP server starting", "addr", httpServer.Addr)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("could not start HTTP server", "addr", httpServer.Addr, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Create the admin server if enabled
+	var adminServer *http.Server
+	if config.AdminEnabled {
+		adminServer = &http.Server{
+			Addr:     net.JoinHostPort(config.AdminBindAddress, strconv.Itoa(config.AdminPort)),
+			Handler:  NewAdminHandler(reloader.Current),
+			ErrorLog: errorLog,
+		}
+		go func() {
+			slog.Info("admin server starting", "addr", adminServer.Addr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("could not start admin server", "addr", adminServer.Addr, "error", err)
+				os.Exit(1)
+			}
+		}()
 	}
 
 	// Channel to listen for interrupt signal
@@ -102,15 +189,22 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Server starting on %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Could not start server: %v", err)
+		slog.Info("server starting", "addr", server.Addr, "tls", server.TLSConfig != nil)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("could not start server", "addr", server.Addr, "error", err)
+			os.Exit(1)
 		}
 	}()
 
 	// Wait for interrupt signal
-	<-quit
-	log.Println("Server is shutting down...")
+	sig := <-quit
+	slog.Info("server is shutting down", "signal", sig.String())
 
 	// Create shutdown context with timeout
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
@@ -118,9 +212,15 @@ func main() {
 
 	// Attempt graceful shutdown
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Error("server forced to shutdown", "error", err)
 	} else {
-		log.Println("Server shutdown complete")
+		slog.Info("server shutdown complete")
+	}
+	if adminServer != nil {
+		adminServer.Shutdown(shutdownCtx)
+	}
+	if httpServer != nil {
+		httpServer.Shutdown(shutdownCtx)
 	}
 
 	close(done)
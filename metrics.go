@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLatencyBuckets are the upper bounds, in seconds, of the request
+// latency histogram buckets
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// labelSeparator joins label values into map keys; it cannot appear in
+// valid UTF-8 label values
+const labelSeparator = "\xff"
+
+// counterVec is a set of counters partitioned by label values
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]float64
+	mu     sync.Mutex
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+}
+
+// Inc adds one to the counter for the given label values
+func (c *counterVec) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, labelSeparator)]++
+}
+
+// Value returns the counter for the given label values
+func (c *counterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, labelSeparator)]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeMetricHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, splitLabelKey(key)), formatFloat(c.values[key]))
+	}
+}
+
+// histogram holds the observations for one set of label values
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a set of histograms partitioned by label values
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string]*histogram
+	mu      sync.Mutex
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+}
+
+// Observe records a value in the histogram for the given label values
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, labelSeparator)
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		hist := h.values[key]
+		labelValues := splitLabelKey(key)
+		withLE := func(le string) []string {
+			return append(append([]string{}, labelValues...), le)
+		}
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, withLE(formatFloat(bound))), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, withLE("+Inf")), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, labelValues), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, labelValues), hist.count)
+	}
+}
+
+// gaugeSample is one value of a gauge computed at scrape time
+type gaugeSample struct {
+	labelValues []string
+	value       float64
+}
+
+// writeGauge writes a gauge and its samples
+func writeGauge(w io.Writer, name, help string, labels []string, samples []gaugeSample) {
+	writeMetricHeader(w, name, help, "gauge")
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, sample.labelValues), formatFloat(sample.value))
+	}
+}
+
+// writeCounter writes a counter whose samples are read at scrape time
+func writeCounter(w io.Writer, name, help string, labels []string, samples []gaugeSample) {
+	writeMetricHeader(w, name, help, "counter")
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, sample.labelValues), formatFloat(sample.value))
+	}
+}
+
+// Metrics collects proxy metrics and exposes them in the Prometheus text
+// exposition format
+type Metrics struct {
+	requests       *counterVec
+	duration       *histogramVec
+	cacheRequests  *counterVec
+	rateLimited    *counterVec
+	upstreamErrors *counterVec
+	inFlight       int64
+}
+
+// NewMetrics creates an empty set of proxy metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newCounterVec("proxy_http_requests_total",
+			"Total HTTP requests handled by the proxy.", "route", "method", "status", "backend"),
+		duration: newHistogramVec("proxy_http_request_duration_seconds",
+			"HTTP request latency in seconds.", defaultLatencyBuckets, "route", "method", "status", "backend"),
+		cacheRequests: newCounterVec("proxy_cache_requests_total",
+			"Cache lookups by result (hit, miss, bypass).", "route", "result"),
+		rateLimited: newCounterVec("proxy_rate_limit_rejections_total",
+			"Requests rejected by the rate limiter.", "route"),
+		upstreamErrors: newCounterVec("proxy_upstream_errors_total",
+			"Failed attempts to reach a backend by reason.", "backend", "reason"),
+	}
+}
+
+// methodLabel returns the method label for a request. Methods other than
+// the standard ones are reported as OTHER so clients cannot create
+// unbounded series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// observe records a completed request
+func (m *Metrics) observe(r *http.Request, status int, duration time.Duration, info requestInfoSnapshot) {
+	statusLabel := strconv.Itoa(status)
+	method := methodLabel(r.Method)
+	m.requests.Inc(info.Route, method, statusLabel, info.Backend)
+	m.duration.Observe(duration.Seconds(), info.Route, method, statusLabel, info.Backend)
+
+	if info.CacheStatus != "" {
+		m.cacheRequests.Inc(info.Route, strings.ToLower(info.CacheStatus))
+	}
+	if info.RateLimited {
+		m.rateLimited.Inc(info.Route)
+	}
+	for _, upstreamErr := range info.UpstreamErrors {
+		m.upstreamErrors.Inc(upstreamErr.Backend, upstreamErr.Reason)
+	}
+}
+
+// Write writes all request metrics followed by the gauges of the proxy, if any
+func (m *Metrics) Write(w io.Writer, proxy *Proxy) {
+	m.requests.write(w)
+	m.duration.write(w)
+	writeGauge(w, "proxy_http_requests_in_flight", "HTTP requests currently being handled.",
+		nil, []gaugeSample{{value: float64(atomic.LoadInt64(&m.inFlight))}})
+	m.cacheRequests.write(w)
+	m.rateLimited.write(w)
+	m.upstreamErrors.write(w)
+
+	if proxy != nil {
+		proxy.writeMetrics(w)
+	}
+}
+
+// Handler serves the metrics of the given proxy
+func (m *Metrics) Handler(proxy *Proxy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.Write(w, proxy)
+	})
+}
+
+// metricsMiddleware records request counts, latencies and in-flight requests
+func metricsMiddleware(m *Metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r)
+
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+
+		start := time.Now()
+		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(lrw, r)
+
+		m.observe(r, lrw.statusCode, time.Since(start), info.snapshot())
+	})
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders label pairs as {name="value",...}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func splitLabelKey(key string) []string {
+	return strings.Split(key, labelSeparator)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterVec_Write(t *testing.T) {
+	c := newCounterVec("test_total", "A test counter.", "route", "status")
+	c.Inc("api", "200")
+	c.Inc("api", "200")
+	c.Inc("web", `5"0\0`)
+
+	var buf bytes.Buffer
+	c.write(&buf)
+
+	assert.Equal(t, float64(2), c.Value("api", "200"))
+	assert.Equal(t, "# HELP test_total A test counter.\n"+
+		"# TYPE test_total counter\n"+
+		"test_total{route=\"api\",status=\"200\"} 2\n"+
+		"test_total{route=\"web\",status=\"5\\\"0\\\\0\"} 1\n", buf.String())
+}
+
+func TestHistogramVec_Write(t *testing.T) {
+	h := newHistogramVec("test_seconds", "A test histogram.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "api")
+	h.Observe(0.5, "api")
+	h.Observe(5, "api")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE test_seconds histogram\n")
+	assert.Contains(t, out, `test_seconds_bucket{route="api",le="0.1"} 1`)
+	assert.Contains(t, out, `test_seconds_bucket{route="api",le="1"} 2`)
+	assert.Contains(t, out, `test_seconds_bucket{route="api",le="+Inf"} 3`)
+	assert.Contains(t, out, `test_seconds_sum{route="api"} 5.55`)
+	assert.Contains(t, out, `test_seconds_count{route="api"} 3`)
+}
+
+func TestMetricsMiddleware_RecordsRequests(t *testing.T) {
+	m := NewMetrics()
+	handler := metricsMiddleware(m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := getRequestInfo(r.Context())
+		info.setRoute("api")
+		info.setBackend("backend:80")
+		info.setCacheStatus("MISS")
+		info.addUpstreamError("other:80", "connection_error")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/items", nil))
+
+	assert.Equal(t, float64(1), m.requests.Value("api", "POST", "201", "backend:80"))
+	assert.Equal(t, float64(1), m.cacheRequests.Value("api", "miss"))
+	assert.Equal(t, float64(1), m.upstreamErrors.Value("other:80", "connection_error"))
+	assert.Equal(t, int64(0), m.inFlight)
+}
+
+func TestMetricsMiddleware_NonStandardMethods(t *testing.T) {
+	m := NewMetrics()
+	handler := metricsMiddleware(m, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		getRequestInfo(r.Context()).setRoute("api")
+	}))
+
+	for _, method := range []string{"GET", "XJUNK1", "PURGE", "get"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/items", nil))
+	}
+
+	assert.Equal(t, float64(1), m.requests.Value("api", "GET", "200", ""))
+	assert.Equal(t, float64(3), m.requests.Value("api", "OTHER", "200", ""))
+	var out strings.Builder
+	m.Write(&out, nil)
+	assert.NotContains(t, out.String(), "XJUNK1")
+}
+
+func TestProxy_MetricsEndpoint(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	config := &Config{
+		Backend:          backend.URL,
+		RequestTimeout:   5,
+		CacheEnabled:     true,
+		CacheSize:        10,
+		CacheTTL:         60,
+		RateLimitEnabled: true,
+		RateLimitRPM:     60,
+		RateLimitBurst:   1,
+		MetricsPath:      "/metrics",
+	}
+	proxy, err := NewProxy(config, NewMetrics())
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/page", nil))
+	}
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+
+	host := strings.TrimPrefix(backend.URL, "http://")
+	body := w.Body.String()
+	assert.Contains(t, body, `proxy_http_requests_total{route="default",method="GET",status="200",backend="`+host+`"} 1`)
+	assert.Contains(t, body, `proxy_http_requests_total{route="default",method="GET",status="429",backend=""} 2`)
+	assert.Contains(t, body, `proxy_cache_requests_total{route="default",result="miss"} 1`)
+	assert.Contains(t, body, `proxy_rate_limit_rejections_total{route="default"} 2`)
+	assert.Contains(t, body, "proxy_http_requests_in_flight 0")
+	assert.Contains(t, body, `proxy_backend_up{route="default",backend="`+host+`"} 1`)
+	assert.Contains(t, body, `proxy_cache_entries{cache="shared"} 1`)
+	assert.Contains(t, body, `proxy_rate_limit_buckets{limiter="shared"} 1`)
+}
+
+func TestUpstreamErrorReason(t *testing.T) {
+	assert.Equal(t, "http_503", upstreamErrorReason(&http.Response{StatusCode: 503}, nil))
+	assert.Equal(t, "circuit_open", upstreamErrorReason(nil, errCircuitOpen))
+	assert.Equal(t, "connection_error", upstreamErrorReason(nil, assert.AnError))
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	"fmt"
-	"log"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -17,6 +20,7 @@ type cachingResponseWriter struct {
 	body       *bytes.Buffer
 	headers    map[string][]string
 	written    bool
+	streaming  bool // streamed responses are passed through without buffering
 }
 
 func newCachingResponseWriter(w http.ResponseWriter) *cachingResponseWriter {
@@ -32,6 +36,9 @@ func newCachingResponseWriter(w http.ResponseWriter) *cachingResponseWriter {
 func (crw *cachingResponseWriter) WriteHeader(code int) {
 	if !crw.written {
 		crw.statusCode = code
+		if isEventStream(crw.Header()) {
+			crw.startStreaming()
+		}
 		crw.ResponseWriter.WriteHeader(code)
 		crw.written = true
 	}
@@ -41,14 +48,47 @@ func (crw *cachingResponseWriter) Write(data []byte) (int, error) {
 	if !crw.written {
 		crw.WriteHeader(crw.statusCode)
 	}
-	crw.body.Write(data)
+	if !crw.streaming {
+		crw.body.Write(data)
+	}
 	return crw.ResponseWriter.Write(data)
 }
 
+// Flush sends buffered data to the client. A flushed response is a stream,
+// such as a body of unknown length relayed by the proxy, and is not cached.
+func (crw *cachingResponseWriter) Flush() {
+	if !crw.written {
+		crw.WriteHeader(crw.statusCode)
+	}
+	crw.startStreaming()
+	flush(crw.ResponseWriter)
+}
+
+// startStreaming stops buffering the body for the cache
+func (crw *cachingResponseWriter) startStreaming() {
+	crw.streaming = true
+	crw.body = &bytes.Buffer{}
+}
+
 func (crw *cachingResponseWriter) Header() http.Header {
 	return crw.ResponseWriter.Header()
 }
 
+// Hijack hands the connection to the handler; the response is never cached
+func (crw *cachingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(crw.ResponseWriter)
+	if err == nil {
+		crw.statusCode = http.StatusSwitchingProtocols
+		crw.written = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (crw *cachingResponseWriter) Unwrap() http.ResponseWriter {
+	return crw.ResponseWriter
+}
+
 // shouldCacheResponse determines if a response should be cached
 func shouldCacheResponse(req *http.Request, resp *cachingResponseWriter) bool {
 	// Only cache GET requests
@@ -56,8 +96,8 @@ func shouldCacheResponse(req *http.Request, resp *cachingResponseWriter) bool {
 		return false
 	}
 
-	// Don't cache error responses
-	if resp.statusCode >= 400 {
+	// Don't cache error responses, protocol switches or streams
+	if resp.statusCode >= 400 || resp.statusCode == http.StatusSwitchingProtocols || resp.streaming {
 		return false
 	}
 
@@ -70,18 +110,45 @@ func shouldCacheResponse(req *http.Request, resp *cachingResponseWriter) bool {
 	return true
 }
 
-// generateCacheKey creates a unique key for the request
+// generateCacheKey creates a unique key for the request. Server requests
+// carry only the path in their URL, so the Host header is added to keep
+// responses for different virtual hosts apart.
 func generateCacheKey(req *http.Request) string {
-	return req.Method + "|" + req.URL.String()
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	return req.Method + "|" + u.String()
 }
 
 // cachingMiddleware provides response caching
 func cachingMiddleware(cache *Cache, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cacheKey := generateCacheKey(r)
+		info := getRequestInfo(r.Context())
+
+		// Upgraded connections are never served from or stored in the cache
+		if isUpgradeRequest(r) {
+			info.setCacheStatus("BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only GET responses are cached, so other methods skip the lookup
+		if r.Method != http.MethodGet {
+			info.setCacheStatus("BYPASS")
+			w.Header().Set("X-Cache", "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// Try to get from cache first
-		if cachedResp, found := cache.Get(cacheKey); found {
+		_, span := startSpan(r.Context(), "cache lookup", SpanKindInternal)
+		cachedResp, found := cache.Get(cacheKey)
+		span.SetAttribute("cache.hit", found)
+		span.End()
+		if found {
+			info.setCacheStatus("HIT")
 			// Serve from cache
 			for key, values := range cachedResp.Headers {
 				for _, value := range values {
@@ -114,8 +181,10 @@ func cachingMiddleware(cache *Cache, next http.Handler) http.Handler {
 			}
 
 			cache.Set(cacheKey, cachedResp)
+			info.setCacheStatus("MISS")
 			w.Header().Set("X-Cache", "MISS")
 		} else {
+			info.setCacheStatus("BYPASS")
 			w.Header().Set("X-Cache", "BYPASS")
 		}
 	})
@@ -123,38 +192,71 @@ func cachingMiddleware(cache *Cache, next http.Handler) http.Handler {
 
 // CacheMetrics holds cache performance metrics
 type CacheMetrics struct {
-	Hits   int64
-	Misses int64
-	Size   int
+	Hits        int64
+	Misses      int64
+	Expirations int64
+	Evictions   int64
+	Sets        int64
+	Bytes       int64
+	Size        int
+	Capacity    int
+	HitRatio    float64
 }
 
 // GetCacheMetrics returns current cache metrics
 func GetCacheMetrics(cache *Cache) CacheMetrics {
-	size, _ := cache.Stats()
+	stats := cache.Stats()
 	return CacheMetrics{
-		Hits:   0, // Would need to be tracked separately
-		Misses: 0, // Would need to be tracked separately
-		Size:   size,
+		Hits:        int64(stats.Hits),
+		Misses:      int64(stats.Misses),
+		Expirations: int64(stats.Expirations),
+		Evictions:   int64(stats.Evictions),
+		Sets:        int64(stats.Sets),
+		Bytes:       stats.Bytes,
+		Size:        stats.Size,
+		Capacity:    stats.Capacity,
+		HitRatio:    stats.HitRatio(),
 	}
 }
 
 // ErrorResponse represents a structured error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
-	Code    int    `json:"code,omitempty"`
+	Error     string `json:"error"`
+	Message   string `json:"message,omitempty"`
+	Code      int    `json:"code,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeErrorResponse writes a structured JSON error response for r, or the
+// matching gRPC status when r is a gRPC call
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, status int, errorCode, message string) {
+	if isGRPCRequest(r) {
+		writeGRPCError(w, grpcStatusForHTTP(status), message)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error:     errorCode,
+		Message:   message,
+		Code:      status,
+		RequestID: getRequestInfo(r.Context()).snapshot().ID,
+	})
 }
 
 // errorHandlingMiddleware provides centralized error handling and recovery
 func errorHandlingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = withRequestInfo(r)
+		start := time.Now()
+
 		// Recover from panics
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintf(w, `{"error":"internal_server_error","message":"An unexpected error occurred"}`)
+				attrs := append(requestAttrs(r), "status", http.StatusInternalServerError,
+					"duration", time.Since(start), "error", err)
+				slog.Error("panic recovered", attrs...)
+				writeErrorResponse(w, r, http.StatusInternalServerError, "internal_server_error", "An unexpected error occurred")
 			}
 		}()
 
@@ -162,18 +264,29 @@ func errorHandlingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// timeoutMiddleware adds timeout handling to requests
-func timeoutMiddleware(timeout time.Duration, next http.Handler) http.Handler {
+// timeoutMiddleware adds timeout handling to requests. Once a response is
+// streamed, such as server-sent events, the request timeout no longer
+// applies; the stream is cut off after streamIdleTimeout without data
+// instead. Zero disables either timeout. Upgrade requests are exempt; their
+// connections are bounded by the upgrade limits instead.
+func timeoutMiddleware(timeout, streamIdleTimeout time.Duration, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), timeout)
-		defer cancel()
+		if isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
 
 		r = r.WithContext(ctx)
+		tw := newTimeoutResponseWriter(w, timeout, streamIdleTimeout, cancel)
+		defer tw.stop()
 
 		done := make(chan bool, 1)
 
 		go func() {
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(tw, r)
 			done <- true
 		}()
 
@@ -182,12 +295,14 @@ func timeoutMiddleware(timeout time.Duration, next http.Handler) http.Handler {
 			// Request completed normally
 			return
 		case <-ctx.Done():
-			// Timeout occurred
-			if ctx.Err() == context.DeadlineExceeded {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusGatewayTimeout)
-				fmt.Fprintf(w, `{"error":"gateway_timeout","message":"Request timed out"}`)
+			// A stream has already sent its headers, so it just ends
+			if tw.Streaming() {
+				<-done
+				return
 			}
+			// Timeout occurred or the client went away; the handler's
+			// later writes are dropped
+			tw.abandon(r, errors.Is(context.Cause(ctx), context.DeadlineExceeded))
 		}
 	})
 }
@@ -211,6 +211,15 @@ func TestGenerateCacheKey_POST(t *testing.T) {
 	assert.Equal(t, expected, generateCacheKey(req))
 }
 
+func TestGenerateCacheKey_IncludesHost(t *testing.T) {
+	req1 := httptest.NewRequest("GET", "/index.html", nil)
+	req1.Host = "a.example.com"
+	req2 := httptest.NewRequest("GET", "/index.html", nil)
+	req2.Host = "b.example.com"
+
+	assert.NotEqual(t, generateCacheKey(req1), generateCacheKey(req2))
+}
+
 func TestCachingResponseWriter_WriteHeader(t *testing.T) {
 	w := httptest.NewRecorder()
 	crw := newCachingResponseWriter(w)
@@ -316,6 +325,21 @@ func TestCachingMiddleware_CacheBypass(t *testing.T) {
 	assert.Equal(t, "BYPASS", w.Header().Get("X-Cache"))
 }
 
+func TestCachingMiddleware_NonGETSkipsLookup(t *testing.T) {
+	cache := NewCache(10, 60)
+	handler := cachingMiddleware(cache, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("response"))
+	}))
+
+	before := cache.Stats()
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "http://example.com/test", nil))
+		assert.Equal(t, "BYPASS", w.Header().Get("X-Cache"), method)
+	}
+	assert.Equal(t, before.Misses, cache.Stats().Misses)
+}
+
 func TestCachingMiddleware_ErrorResponseNotCached(t *testing.T) {
 	cache := NewCache(10, 60)
 
@@ -348,11 +372,17 @@ func TestGetCacheMetrics(t *testing.T) {
 	cache.Set("key1", &CachedResponse{StatusCode: 200})
 	cache.Set("key2", &CachedResponse{StatusCode: 200})
 
+	cache.Get("key1")
+	cache.Get("key3")
+
 	metrics := GetCacheMetrics(cache)
 
 	assert.Equal(t, 2, metrics.Size)
-	assert.Equal(t, int64(0), metrics.Hits)   // Not tracking hits in this implementation
-	assert.Equal(t, int64(0), metrics.Misses) // Not tracking misses in this implementation
+	assert.Equal(t, 5, metrics.Capacity)
+	assert.Equal(t, int64(1), metrics.Hits)
+	assert.Equal(t, int64(1), metrics.Misses)
+	assert.Equal(t, int64(2), metrics.Sets)
+	assert.Equal(t, 0.5, metrics.HitRatio)
 }
 
 // Mock response writer for testing
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Client certificate verification modes for tls_client_auth
+const (
+	ClientAuthNone     = "none"     // certificates are not requested
+	ClientAuthOptional = "optional" // certificates are verified if sent
+	ClientAuthRequire  = "require"  // every connection must send a valid certificate
+)
+
+// clientAuthTypes maps tls_client_auth values to crypto/tls modes
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	ClientAuthNone:     tls.NoClientCert,
+	ClientAuthOptional: tls.VerifyClientCertIfGiven,
+	ClientAuthRequire:  tls.RequireAndVerifyClientCert,
+}
+
+// Headers carrying the verified client certificate to backends
+const (
+	clientCertSubjectHeader = "X-Client-Cert-Subject"
+	clientCertSANHeader     = "X-Client-Cert-SAN"
+)
+
+// BackendTLSConfig holds the settings for dialing HTTPS backends. Empty
+// fields use the system roots, no client certificate and the URL's host.
+type BackendTLSConfig struct {
+	CAFile     string `json:"ca_file"`     // PEM bundle trusted for backend certificates
+	CertFile   string `json:"cert_file"`   // client certificate presented to backends
+	KeyFile    string `json:"key_file"`    // private key of the client certificate
+	ServerName string `json:"server_name"` // name verified in backend certificates and sent as SNI
+}
+
+// withDefaults fills unset fields from defaults. The certificate and key
+// are taken as a pair.
+func (c BackendTLSConfig) withDefaults(defaults BackendTLSConfig) BackendTLSConfig {
+	if c.CAFile == "" {
+		c.CAFile = defaults.CAFile
+	}
+	if c.CertFile == "" && c.KeyFile == "" {
+		c.CertFile, c.KeyFile = defaults.CertFile, defaults.KeyFile
+	}
+	if c.ServerName == "" {
+		c.ServerName = defaults.ServerName
+	}
+	return c
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// newBackendTransport returns the transport for a backend, using
+// http.DefaultTransport unless TLS settings or a protocol are given
+func newBackendTransport(config *BackendTLSConfig, protocol string) (http.RoundTripper, error) {
+	hasTLS := config != nil && *config != (BackendTLSConfig{})
+	protocols := backendProtocols(protocol)
+	if !hasTLS && protocols == nil {
+		return http.DefaultTransport, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = protocols
+	if !hasTLS {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: config.ServerName}
+	if config.CAFile != "" {
+		pool, err := loadCertPool(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend CA bundle: %v", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// verifiedClientCert returns the client certificate verified during the TLS
+// handshake, or nil if there is none
+func verifiedClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// subjectAltNames formats a certificate's subject alternative names the way
+// OpenSSL prints them, such as "DNS:api.example.com, URI:spiffe://example/api"
+func subjectAltNames(cert *x509.Certificate) string {
+	var names []string
+	for _, name := range cert.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// clientCertMiddleware passes the verified client certificate's subject and
+// subject alternative names to backends. Copies of these headers sent by the
+// client are always removed so they cannot be forged.
+func clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(clientCertSubjectHeader)
+		r.Header.Del(clientCertSANHeader)
+		if cert := verifiedClientCert(r); cert != nil {
+			r.Header.Set(clientCertSubjectHeader, cert.Subject.String())
+			if san := subjectAltNames(cert); san != "" {
+				r.Header.Set(clientCertSANHeader, san)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireClientCertMiddleware rejects requests made without a verified
+// client certificate
+func requireClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if verifiedClientCert(r) == nil {
+			writeErrorResponse(w, r, http.StatusForbidden, "client_certificate_required", "A valid client certificate is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errClientCAMissing is returned when client certificates are requested
+// without a CA bundle to verify them
+var errClientCAMissing = errors.New("tls_client_ca_file is required to verify client certificates")
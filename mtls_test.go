@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClientCert returns a parsed certificate as seen after verification
+func newTestClientCert() *x509.Certificate {
+	return &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "client", Organization: []string{"Example"}},
+		DNSNames:       []string{"client.example.com"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/client"}},
+		EmailAddresses: []string{"ops@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+	}
+}
+
+// withClientCert returns the request as received over TLS with cert verified
+func withClientCert(r *http.Request, cert *x509.Certificate) *http.Request {
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	return r
+}
+
+func TestClientCertMiddleware(t *testing.T) {
+	var subject, san string
+	handler := clientCertMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, san = r.Header.Get(clientCertSubjectHeader), r.Header.Get(clientCertSANHeader)
+	}))
+
+	req := withClientCert(httptest.NewRequest("GET", "/", nil), newTestClientCert())
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "CN=client,O=Example", subject)
+	assert.Equal(t, "DNS:client.example.com, URI:spiffe://example.com/client, email:ops@example.com, IP:10.0.0.1", san)
+
+	// Headers sent by the client are never trusted
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(clientCertSubjectHeader, "CN=admin")
+	req.Header.Set(clientCertSANHeader, "DNS:admin.example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, subject)
+	assert.Empty(t, san)
+
+	// Certificates that were sent but not verified are ignored
+	req = httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{newTestClientCert()}}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, subject)
+}
+
+func TestRequireClientCertMiddleware(t *testing.T) {
+	handler := requireClientCertMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "client_certificate_required")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, withClientCert(httptest.NewRequest("GET", "/", nil), newTestClientCert()))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestProxy_ClientCertificates(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(clientCertSubjectHeader)))
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	serverCert := writeTestCertificate(t, dir, "proxy", "localhost")
+	clientCert := writeTestCertificate(t, dir, "client", "client.example.com")
+
+	config := newTestReloadConfig(backend.URL)
+	config.TLSClientAuth = ClientAuthOptional
+	config.TLSClientCAFile = clientCert.CertFile
+	config.Routes = []RouteConfig{
+		{Name: "secure", PathPrefix: "/secure", ClientCertRequired: true, Backends: []BackendConfig{{URL: backend.URL}}},
+		{Name: "public", PathPrefix: "/", Backends: []BackendConfig{{URL: backend.URL}}},
+	}
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	store, err := newCertStore([]TLSCertificateConfig{serverCert})
+	assert.NoError(t, err)
+	server := httptest.NewUnstartedServer(proxy)
+	server.TLS, err = newServerTLSConfig(config.TLS(), store.GetCertificate)
+	assert.NoError(t, err)
+	server.StartTLS()
+	defer server.Close()
+
+	roots, err := loadCertPool(serverCert.CertFile)
+	assert.NoError(t, err)
+	get := func(path string, certs ...tls.Certificate) (int, string) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: certs,
+		}}}
+		resp, err := client.Get(server.URL + path)
+		if !assert.NoError(t, err) {
+			return 0, ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	cert, err := tls.LoadX509KeyPair(clientCert.CertFile, clientCert.KeyFile)
+	assert.NoError(t, err)
+
+	status, body := get("/secure", cert)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "CN=client", body)
+
+	status, _ = get("/secure")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, body = get("/public")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Empty(t, body)
+}
+
+func TestBackendPool_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	backendCert := writeTestCertificate(t, dir, "backend", "backend.internal")
+	clientCert := writeTestCertificate(t, dir, "proxy-client", "proxy.internal")
+
+	backendStore, err := newCertStore([]TLSCertificateConfig{backendCert})
+	assert.NoError(t, err)
+	clientCAs, err := loadCertPool(clientCert.CertFile)
+	assert.NoError(t, err)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	backend.TLS = &tls.Config{
+		GetCertificate: backendStore.GetCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      clientCAs,
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	config := &BackendTLSConfig{
+		CAFile:     backendCert.CertFile,
+		CertFile:   clientCert.CertFile,
+		KeyFile:    clientCert.KeyFile,
+		ServerName: "backend.internal",
+	}
+	pool, err := NewBackendPool([]BackendConfig{{URL: backend.URL, TLS: config}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	defer pool.closeIdleConnections()
+
+	rr := httptest.NewRecorder()
+	pool.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "proxy-client", rr.Body.String())
+
+	// Without a client certificate the backend refuses the handshake
+	pool, err = NewBackendPool([]BackendConfig{{URL: backend.URL, TLS: &BackendTLSConfig{
+		CAFile:     backendCert.CertFile,
+		ServerName: "backend.internal",
+	}}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	pool.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+}
+
+func TestNewBackendTransport(t *testing.T) {
+	transport, err := newBackendTransport(nil, "")
+	assert.NoError(t, err)
+	assert.Same(t, http.DefaultTransport, transport)
+
+	_, err = newBackendTransport(&BackendTLSConfig{CAFile: "missing.pem"}, "")
+	assert.ErrorContains(t, err, "failed to load backend CA bundle")
+
+	_, err = NewBackend(BackendConfig{URL: "https://backend.test", TLS: &BackendTLSConfig{CertFile: "missing.crt", KeyFile: "missing.key"}})
+	assert.ErrorContains(t, err, "backend https://backend.test: failed to load backend client certificate")
+}
+
+func TestConfig_BackendTLSDefaults(t *testing.T) {
+	config := defaultConfig()
+	config.BackendTLSCAFile = "ca.pem"
+	config.BackendTLSCertFile = "client.crt"
+	config.BackendTLSKeyFile = "client.key"
+	config.Backends = []BackendConfig{
+		{URL: "https://a.internal"},
+		{URL: "https://b.internal", TLS: &BackendTLSConfig{CertFile: "b.crt", KeyFile: "b.key", ServerName: "b"}},
+		{URL: "http://plain.internal"},
+	}
+
+	backends := config.RouteList()[0].Backends
+	assert.Equal(t, &BackendTLSConfig{CAFile: "ca.pem", CertFile: "client.crt", KeyFile: "client.key"}, backends[0].TLS)
+	assert.Equal(t, &BackendTLSConfig{CAFile: "ca.pem", CertFile: "b.crt", KeyFile: "b.key", ServerName: "b"}, backends[1].TLS)
+	assert.Nil(t, backends[2].TLS)
+	assert.Nil(t, config.Backends[0].TLS, "the configured backends are not modified")
+}
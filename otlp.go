@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLP exporter tuning
+const (
+	otlpQueueSize     = 2048 // spans buffered before new ones are dropped
+	otlpBatchSize     = 512  // spans sent per request
+	otlpFlushInterval = 5 * time.Second
+	otlpTimeout       = 10 * time.Second
+)
+
+// otlpExporter batches spans and sends them to an OTLP/HTTP collector using
+// the JSON encoding
+type otlpExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+	queue       chan *Span
+	stop        chan struct{}
+	done        chan struct{}
+	once        sync.Once
+	interval    time.Duration
+}
+
+func newOTLPExporter(endpoint, serviceName string) *otlpExporter {
+	e := &otlpExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpTimeout},
+		queue:       make(chan *Span, otlpQueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		interval:    otlpFlushInterval,
+	}
+	go e.run()
+	return e
+}
+
+// export queues a span, dropping it if the queue is full so a slow
+// collector never blocks requests
+func (e *otlpExporter) export(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+	}
+}
+
+// shutdown sends the queued spans and stops the exporter
+func (e *otlpExporter) shutdown() {
+	e.once.Do(func() { close(e.stop) })
+	<-e.done
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= otlpBatchSize {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.send(batch)
+			batch = nil
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					for len(batch) > otlpBatchSize {
+						e.send(batch[:otlpBatchSize])
+						batch = batch[otlpBatchSize:]
+					}
+					e.send(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts a batch of spans, logging failures
+func (e *otlpExporter) send(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		slog.Warn("trace export failed", "error", err)
+		return
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("trace export failed", "endpoint", e.endpoint, "spans", len(spans), "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		slog.Warn("trace export failed", "endpoint", e.endpoint, "spans", len(spans),
+			"error", fmt.Sprintf("collector returned %d", resp.StatusCode))
+	}
+}
+
+// OTLP JSON types, following opentelemetry/proto/collector/trace/v1
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 0 unset, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 as a decimal string
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+// request builds the export request for a batch of spans
+func (e *otlpExporter) request(spans []*Span) otlpTraceRequest {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		converted = append(converted, span.otlp())
+	}
+
+	return otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: newOTLPValue(e.serviceName)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "reverse-proxy"}, Spans: converted}},
+	}}}
+}
+
+// otlp converts a finished span to its OTLP form
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:           s.context.TraceID.String(),
+		SpanID:            s.context.SpanID.String(),
+		TraceState:        s.context.TraceState,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentID != (SpanID{}) {
+		span.ParentSpanID = s.parentID.String()
+	}
+	for _, attr := range s.attributes {
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: attr.Key, Value: newOTLPValue(attr.Value)})
+	}
+	if s.failed {
+		span.Status = otlpStatus{Code: 2, Message: s.message}
+	}
+	return span
+}
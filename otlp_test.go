@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCollector stands in for an OTLP/HTTP collector, recording the
+// decoded export requests
+func newTestCollector(t *testing.T) (*httptest.Server, func() []otlpTraceRequest) {
+	var mu sync.Mutex
+	var requests []otlpTraceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var req otlpTraceRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []otlpTraceRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]otlpTraceRequest(nil), requests...)
+	}
+}
+
+func TestOTLPExporter_SendsSpansOnShutdown(t *testing.T) {
+	collector, requests := newTestCollector(t)
+	tracer := NewTracer(TracingConfig{Endpoint: collector.URL + "/v1/traces", ServiceName: "edge-proxy", SampleRatio: 1})
+
+	parent := tracer.newSpan("GET api", SpanKindServer, SpanContext{}, false)
+	parent.SetAttribute("http.response.status_code", 502)
+	parent.SetAttribute("http.route", "api")
+	parent.SetAttribute("cache.hit", false)
+	parent.SetError("status 502")
+	_, child := startSpan(contextWithSpan(context.Background(), parent), "upstream GET", SpanKindClient)
+	child.End()
+	parent.End()
+	tracer.Close()
+
+	sent := requests()
+	if !assert.Len(t, sent, 1) {
+		return
+	}
+	resource := sent[0].ResourceSpans[0]
+	assert.Equal(t, "service.name", resource.Resource.Attributes[0].Key)
+	assert.Equal(t, "edge-proxy", *resource.Resource.Attributes[0].Value.StringValue)
+
+	spans := resource.ScopeSpans[0].Spans
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	upstream, server := spans[0], spans[1]
+	assert.Equal(t, "upstream GET", upstream.Name)
+	assert.Equal(t, SpanKindClient, upstream.Kind)
+	assert.Equal(t, server.SpanID, upstream.ParentSpanID)
+	assert.Equal(t, server.TraceID, upstream.TraceID)
+	assert.Len(t, server.TraceID, 32)
+	assert.Empty(t, server.ParentSpanID)
+
+	assert.Equal(t, 2, server.Status.Code)
+	assert.Equal(t, "status 502", server.Status.Message)
+	assert.Equal(t, "502", *server.Attributes[0].Value.IntValue)
+	assert.Equal(t, "api", *server.Attributes[1].Value.StringValue)
+	assert.Equal(t, false, *server.Attributes[2].Value.BoolValue)
+	assert.NotEmpty(t, server.StartTimeUnixNano)
+	assert.NotEmpty(t, server.EndTimeUnixNano)
+}
+
+func TestOTLPExporter_CollectorDownIsLogged(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	tracer := NewTracer(TracingConfig{Endpoint: "http://127.0.0.1:1/v1/traces", SampleRatio: 1})
+	tracer.newSpan("op", SpanKindInternal, SpanContext{}, false).End()
+	tracer.Close()
+
+	assert.Contains(t, helper.GetLogs(), "trace export failed")
+}
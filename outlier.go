@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OutlierConfig holds the settings for passive outlier detection
+type OutlierConfig struct {
+	ConsecutiveFailures int           // failures in a row that trigger an ejection
+	BaseEjection        time.Duration // ejection time for the first ejection
+	MaxEjection         time.Duration // cap for the exponentially growing ejection time
+	Recovery            time.Duration // ramp-up period after an ejection ends
+}
+
+// OutlierDetector watches live traffic to a backend and ejects it after
+// consecutive failures. Each repeated ejection doubles the ejection time,
+// and once it ends the backend is re-admitted gradually over the recovery
+// period instead of receiving its full share of traffic at once.
+type OutlierDetector struct {
+	config              OutlierConfig
+	consecutiveFailures int
+	ejections           int
+	ejectedUntil        time.Time
+	mu                  sync.Mutex
+	now                 func() time.Time
+}
+
+// NewOutlierDetector creates an outlier detector
+func NewOutlierDetector(config OutlierConfig) *OutlierDetector {
+	if config.ConsecutiveFailures <= 0 {
+		config.ConsecutiveFailures = 1
+	}
+	if config.MaxEjection < config.BaseEjection {
+		config.MaxEjection = config.BaseEjection
+	}
+
+	return &OutlierDetector{
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// Record registers the outcome of a request and reports whether it caused
+// the backend to be ejected, along with the ejection duration
+func (d *OutlierDetector) Record(success bool) (ejected bool, duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if success {
+		d.consecutiveFailures = 0
+		// A backend that made it through recovery starts over at the base ejection time
+		if d.ejections > 0 && now.After(d.ejectedUntil.Add(d.config.Recovery)) {
+			d.ejections = 0
+		}
+		return false, 0
+	}
+
+	// Failures from requests already in flight when the backend was ejected don't count again
+	if now.Before(d.ejectedUntil) {
+		return false, 0
+	}
+
+	d.consecutiveFailures++
+	if d.consecutiveFailures < d.config.ConsecutiveFailures {
+		return false, 0
+	}
+
+	duration = d.config.BaseEjection << d.ejections
+	if duration > d.config.MaxEjection || duration <= 0 {
+		duration = d.config.MaxEjection
+	} else {
+		d.ejections++
+	}
+
+	d.consecutiveFailures = 0
+	d.ejectedUntil = now.Add(duration)
+	return true, duration
+}
+
+// Ejected reports whether the backend is currently ejected
+func (d *OutlierDetector) Ejected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.now().Before(d.ejectedUntil)
+}
+
+// Admit reports whether a request may be sent to the backend. While
+// recovering, the chance of admission grows linearly to 100%.
+func (d *OutlierDetector) Admit() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if now.Before(d.ejectedUntil) {
+		return false
+	}
+
+	if d.config.Recovery <= 0 || d.ejectedUntil.IsZero() {
+		return true
+	}
+
+	elapsed := now.Sub(d.ejectedUntil)
+	if elapsed >= d.config.Recovery {
+		return true
+	}
+
+	return rand.Float64() < float64(elapsed)/float64(d.config.Recovery)
+}
+
+// isUpstreamFailure reports whether a round trip result counts as a failure
+// of the backend itself
+func isUpstreamFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		// Requests cancelled by the client say nothing about the backend
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= 500
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced clock for time-dependent tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestOutlierDetector(clock *fakeClock) *OutlierDetector {
+	d := NewOutlierDetector(OutlierConfig{
+		ConsecutiveFailures: 3,
+		BaseEjection:        10 * time.Second,
+		MaxEjection:         30 * time.Second,
+		Recovery:            10 * time.Second,
+	})
+	d.now = clock.Now
+	return d
+}
+
+func TestOutlierDetector_EjectsAfterConsecutiveFailures(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	d := newTestOutlierDetector(clock)
+
+	d.Record(false)
+	d.Record(false)
+	assert.False(t, d.Ejected())
+
+	ejected, duration := d.Record(false)
+	assert.True(t, ejected)
+	assert.Equal(t, 10*time.Second, duration)
+	assert.True(t, d.Ejected())
+	assert.False(t, d.Admit())
+}
+
+func TestOutlierDetector_SuccessResetsFailureCount(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	d := newTestOutlierDetector(clock)
+
+	d.Record(false)
+	d.Record(false)
+	d.Record(true)
+	d.Record(false)
+	d.Record(false)
+	assert.False(t, d.Ejected())
+}
+
+func TestOutlierDetector_BackoffGrowsAndCaps(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	d := newTestOutlierDetector(clock)
+
+	var durations []time.Duration
+	for i := 0; i < 4; i++ {
+		var duration time.Duration
+		for j := 0; j < 3; j++ {
+			_, duration = d.Record(false)
+		}
+		durations = append(durations, duration)
+		clock.Advance(duration)
+	}
+
+	assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}, durations)
+}
+
+func TestOutlierDetector_GradualReadmission(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	d := newTestOutlierDetector(clock)
+
+	for i := 0; i < 3; i++ {
+		d.Record(false)
+	}
+	clock.Advance(10 * time.Second)
+	assert.False(t, d.Ejected())
+
+	// Halfway through recovery roughly half of the requests are admitted
+	clock.Advance(5 * time.Second)
+	admitted := 0
+	for i := 0; i < 1000; i++ {
+		if d.Admit() {
+			admitted++
+		}
+	}
+	assert.InDelta(t, 500, admitted, 100)
+
+	// After recovery every request is admitted
+	clock.Advance(5 * time.Second)
+	for i := 0; i < 100; i++ {
+		assert.True(t, d.Admit())
+	}
+}
+
+func TestOutlierDetector_ResetsBackoffAfterRecovery(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	d := newTestOutlierDetector(clock)
+
+	for i := 0; i < 3; i++ {
+		d.Record(false)
+	}
+	clock.Advance(25 * time.Second)
+	d.Record(true)
+
+	var duration time.Duration
+	for i := 0; i < 3; i++ {
+		_, duration = d.Record(false)
+	}
+	assert.Equal(t, 10*time.Second, duration)
+}
+
+func TestIsUpstreamFailure(t *testing.T) {
+	assert.True(t, isUpstreamFailure(nil, errors.New("connection refused")))
+	assert.False(t, isUpstreamFailure(nil, context.Canceled))
+	assert.True(t, isUpstreamFailure(&http.Response{StatusCode: 502}, nil))
+	assert.False(t, isUpstreamFailure(&http.Response{StatusCode: 404}, nil))
+	assert.False(t, isUpstreamFailure(&http.Response{StatusCode: 200}, nil))
+}
+
+func TestBackendPool_OutlierDetectionEjectsFailingBackend(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	var hitsGood int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hitsGood, 1)
+	}))
+	defer good.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: failing.URL}, {URL: good.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	pool.EnableOutlierDetection(OutlierConfig{ConsecutiveFailures: 2, BaseEjection: time.Minute, MaxEjection: time.Minute})
+
+	for i := 0; i < 4; i++ {
+		pool.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	assert.True(t, pool.Backends()[0].Ejected())
+	assert.Contains(t, helper.GetLogs(), "ejecting outlier backend")
+
+	// All further traffic goes to the remaining backend
+	atomic.StoreInt32(&hitsGood, 0)
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		pool.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hitsGood))
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy holds the request handling components built from a Config
+type Proxy struct {
+	config         *Config
+	router         *Router
+	cache          *Cache
+	rateLimiter    *RateLimiter
+	healthCheckers []*HealthChecker
+	accessLog      *AccessLogger
+	tracer         *Tracer
+	handler        http.Handler
+
+	// previous is the proxy being replaced while a reload builds this one
+	previous *Proxy
+
+	// mu guards the count of in-flight requests, which Close waits on
+	// before releasing the access log, tracer and backend connections
+	mu       sync.Mutex
+	inflight int
+	closing  bool
+	drained  chan struct{}
+}
+
+// NewProxy builds the routes, backend pools and middleware chains for a
+// config. Request metrics are recorded if metrics is not nil.
+func NewProxy(config *Config, metrics *Metrics) (*Proxy, error) {
+	return newProxy(config, metrics, nil)
+}
+
+// newProxy builds a proxy, reusing caches, rate limiters, the access log and
+// the tracer with unchanged settings from the previous proxy so a reload
+// keeps their contents. Backends with unchanged URLs keep their state.
+func newProxy(config *Config, metrics *Metrics, previous *Proxy) (*Proxy, error) {
+	p := &Proxy{config: config, previous: previous, drained: make(chan struct{})}
+	defer func() { p.previous = nil }()
+
+	// Shared cache and rate limiter for routes without overrides
+	if config.CacheEnabled {
+		p.cache = p.newCache("shared", config.CacheSize, config.CacheTTL)
+	}
+	if config.RateLimitEnabled {
+		p.rateLimiter = p.newRateLimiter("shared", config.RateLimitRPM, config.RateLimitBurst)
+	}
+
+	var routes []*Route
+	for _, routeConfig := range config.RouteList() {
+		pool, err := p.newPool(routeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %v", routeConfig.Name, err)
+		}
+
+		cache := p.routeCache(routeConfig)
+		rateLimiter := p.routeRateLimiter(routeConfig)
+		timeout := p.routeTimeout(routeConfig)
+		streamIdle := p.routeStreamIdleTimeout(routeConfig)
+
+		// Build the route's middleware chain
+		var handler http.Handler = pool
+		if cache != nil {
+			handler = cachingMiddleware(cache, handler)
+		}
+		if rateLimiter != nil {
+			handler = rateLimitMiddleware(rateLimiter, handler)
+		}
+		if routeConfig.ClientCertRequired {
+			handler = requireClientCertMiddleware(handler)
+		}
+		handler = errorHandlingMiddleware(handler)
+		if timeout > 0 || streamIdle > 0 {
+			handler = timeoutMiddleware(timeout, streamIdle, handler)
+		}
+
+		route, err := NewRoute(routeConfig, handler)
+		if err != nil {
+			return nil, err
+		}
+		route.Pool = pool
+		route.Cache = cache
+		route.RateLimiter = rateLimiter
+		route.Timeout = timeout
+		route.StreamIdle = streamIdle
+		routes = append(routes, route)
+	}
+	p.router = NewRouter(routes)
+
+	// Wrap the router with the middleware shared by all routes. The access
+	// log, when enabled, replaces the per-request lines in the error log.
+	var handler http.Handler = p.router
+	if config.AccessLogEnabled {
+		accessLog, err := p.newAccessLogger(config.AccessLog())
+		if err != nil {
+			return nil, err
+		}
+		p.accessLog = accessLog
+		handler = accessLogMiddleware(accessLog, handler)
+	} else {
+		handler = loggingMiddleware(handler)
+	}
+	handler = errorHandlingMiddleware(handler)
+	handler = clientCertMiddleware(handler)
+	if config.TracingEnabled {
+		p.tracer = p.newTracer(config.Tracing())
+		handler = tracingMiddleware(p.tracer, handler)
+	}
+	if metrics != nil {
+		handler = metricsMiddleware(metrics, handler)
+		handler = serveMetricsAt(config.MetricsPath, metrics.Handler(p), handler)
+	}
+	handler = upgradeMiddleware(config.Upgrade(), handler)
+	if config.RequestIDHeader != "" {
+		handler = requestIDMiddleware(config.RequestID(), handler)
+	}
+	p.handler = handler
+
+	return p, nil
+}
+
+// serveMetricsAt serves the metrics handler at path and passes every other
+// request through to next
+func serveMetricsAt(path string, metricsHandler, next http.Handler) http.Handler {
+	if path == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path {
+			metricsHandler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeCache returns the cache for a route: the shared cache unless the
+// route overrides the cache settings
+func (p *Proxy) routeCache(route RouteConfig) *Cache {
+	override := route.Cache
+	if override == nil {
+		return p.cache
+	}
+
+	enabled := p.config.CacheEnabled
+	if override.Enabled != nil {
+		enabled = *override.Enabled
+	}
+	if !enabled {
+		return nil
+	}
+
+	size, ttl := p.config.CacheSize, p.config.CacheTTL
+	if override.Size > 0 {
+		size = override.Size
+	}
+	if override.TTLSeconds > 0 {
+		ttl = override.TTLSeconds
+	}
+	return p.newCache(route.Name, size, ttl)
+}
+
+// routeRateLimiter returns the rate limiter for a route: the shared limiter
+// unless the route overrides the rate limit settings
+func (p *Proxy) routeRateLimiter(route RouteConfig) *RateLimiter {
+	override := route.RateLimit
+	if override == nil {
+		return p.rateLimiter
+	}
+
+	enabled := p.config.RateLimitEnabled
+	if override.Enabled != nil {
+		enabled = *override.Enabled
+	}
+	if !enabled {
+		return nil
+	}
+
+	rpm, burst := p.config.RateLimitRPM, p.config.RateLimitBurst
+	if override.RequestsPerMinute > 0 {
+		rpm = override.RequestsPerMinute
+	}
+	if override.BurstSize > 0 {
+		burst = override.BurstSize
+	}
+	return p.newRateLimiter(route.Name, rpm, burst)
+}
+
+// newCache returns the previous proxy's cache of the same name if its
+// settings are unchanged, or a new cache otherwise
+func (p *Proxy) newCache(name string, size, ttlSeconds int) *Cache {
+	if p.previous != nil {
+		old := p.previous.caches()[name]
+		if old != nil && old.capacity == size && old.ttl == time.Duration(ttlSeconds)*time.Second {
+			return old
+		}
+	}
+	return NewCache(size, ttlSeconds)
+}
+
+// newRateLimiter returns the previous proxy's rate limiter of the same name
+// if its settings are unchanged, or a new rate limiter otherwise
+func (p *Proxy) newRateLimiter(name string, rpm, burst int) *RateLimiter {
+	if p.previous != nil {
+		old := p.previous.rateLimiters()[name]
+		if old != nil && old.rpm == rpm && old.burstSize == burst {
+			return old
+		}
+	}
+	return NewRateLimiter(rpm, burst)
+}
+
+// newAccessLogger returns the previous proxy's access logger if its settings
+// are unchanged, or opens a new one otherwise
+func (p *Proxy) newAccessLogger(config AccessLogConfig) (*AccessLogger, error) {
+	if p.previous != nil && p.previous.accessLog != nil && p.previous.accessLog.config == config {
+		return p.previous.accessLog, nil
+	}
+	return NewAccessLogger(config)
+}
+
+// newTracer returns the previous proxy's tracer if its settings are
+// unchanged, or a new one otherwise
+func (p *Proxy) newTracer(config TracingConfig) *Tracer {
+	if p.previous != nil && p.previous.tracer != nil && p.previous.tracer.config == config {
+		return p.previous.tracer
+	}
+	return NewTracer(config)
+}
+
+// routeTimeout returns the request timeout for a route, or zero for none
+func (p *Proxy) routeTimeout(route RouteConfig) time.Duration {
+	seconds := p.config.RequestTimeout
+	if override := route.Timeout; override != nil {
+		if override.Enabled != nil && !*override.Enabled {
+			return 0
+		}
+		if override.Seconds > 0 {
+			seconds = override.Seconds
+		}
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// routeStreamIdleTimeout returns how long a streamed response on a route
+// may go without data, or zero for no limit
+func (p *Proxy) routeStreamIdleTimeout(route RouteConfig) time.Duration {
+	seconds := p.config.StreamIdleTimeout
+	if override := route.Timeout; override != nil {
+		if override.Enabled != nil && !*override.Enabled {
+			return 0
+		}
+		if override.StreamIdleSeconds > 0 {
+			seconds = override.StreamIdleSeconds
+		}
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// newPool creates the backend pool for a route, enabling the resilience
+// features turned on in the config
+func (p *Proxy) newPool(route RouteConfig) (*BackendPool, error) {
+	config := p.config
+
+	pool, err := NewBackendPool(route.Backends, route.LoadBalancer, route.HashKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Eject failing backends based on live traffic if enabled
+	if config.OutlierDetectionEnabled {
+		pool.EnableOutlierDetection(config.OutlierDetection())
+	}
+
+	// Fail fast on backends that keep erroring if enabled
+	if config.CircuitBreakerEnabled {
+		pool.EnableCircuitBreakers(config.CircuitBreaker())
+	}
+
+	// Retry failed idempotent requests on other backends if enabled
+	if config.RetryEnabled {
+		pool.EnableRetries(config.Retry())
+	}
+
+	// Keep the state of backends that were already in the route
+	p.inheritBackendState(route.Name, pool)
+
+	// Probe backends actively if enabled
+	if config.HealthCheckEnabled {
+		p.healthCheckers = append(p.healthCheckers, NewHealthChecker(config.HealthCheck(), pool.Backends()))
+	}
+
+	return pool, nil
+}
+
+// inheritBackendState carries over the state of backends whose URL is
+// unchanged from the previous proxy's route of the same name
+func (p *Proxy) inheritBackendState(name string, pool *BackendPool) {
+	if p.previous == nil {
+		return
+	}
+	for _, route := range p.previous.router.Routes() {
+		if route.Name != name {
+			continue
+		}
+		for _, backend := range pool.Backends() {
+			for _, old := range route.Pool.Backends() {
+				if old.URL.String() == backend.URL.String() {
+					backend.inheritState(old)
+					break
+				}
+			}
+		}
+	}
+}
+
+// Start begins background work such as health checks
+func (p *Proxy) Start() {
+	for _, hc := range p.healthCheckers {
+		hc.Start()
+	}
+}
+
+// Stop ends background work started by Start
+func (p *Proxy) Stop() {
+	for _, hc := range p.healthCheckers {
+		hc.Stop()
+	}
+}
+
+// Close stops background work and, once the requests in flight on this
+// proxy have finished, closes idle backend connections and the access log
+// and tracer unless next, the proxy replacing this one, shares them. next
+// may be nil. Close does not wait for the requests to finish.
+func (p *Proxy) Close(next *Proxy) {
+	p.mu.Lock()
+	if p.closing {
+		p.mu.Unlock()
+		return
+	}
+	p.closing = true
+	if p.inflight == 0 {
+		close(p.drained)
+	}
+	p.mu.Unlock()
+
+	p.Stop()
+	release := func() {
+		if next == nil || next.accessLog != p.accessLog {
+			p.accessLog.Close()
+		}
+		if next == nil || next.tracer != p.tracer {
+			p.tracer.Close()
+		}
+		for _, route := range p.router.Routes() {
+			route.Pool.closeIdleConnections()
+		}
+	}
+
+	select {
+	case <-p.drained:
+		release()
+	default:
+		go func() {
+			<-p.drained
+			release()
+		}()
+	}
+}
+
+// acquire counts a request in flight, unless the proxy is closing
+func (p *Proxy) acquire() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closing {
+		return false
+	}
+	p.inflight++
+	return true
+}
+
+// release ends a request counted by acquire
+func (p *Proxy) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inflight--
+	if p.closing && p.inflight == 0 {
+		close(p.drained)
+	}
+}
+
+// serve handles a request counted as in flight. It returns false without
+// handling the request if the proxy is closing.
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) bool {
+	if !p.acquire() {
+		return false
+	}
+	defer p.release()
+	p.handler.ServeHTTP(w, r)
+	return true
+}
+
+// writeMetrics writes gauges describing the current state of the proxy's
+// backends, caches and rate limiters
+func (p *Proxy) writeMetrics(w io.Writer) {
+	var healthy, circuit, active []gaugeSample
+	for _, route := range p.router.Routes() {
+		for _, backend := range route.Pool.Backends() {
+			labels := []string{route.Name, backend.URL.Host}
+			healthy = append(healthy, gaugeSample{labels, boolToFloat(backend.Healthy() && !backend.Ejected())})
+			circuit = append(circuit, gaugeSample{labels, float64(backend.CircuitState())})
+			active = append(active, gaugeSample{labels, float64(backend.ActiveConnections())})
+		}
+	}
+
+	backendLabels := []string{"route", "backend"}
+	writeGauge(w, "proxy_backend_up", "Whether the backend is in rotation (healthy and not ejected).", backendLabels, healthy)
+	writeGauge(w, "proxy_backend_circuit_state", "Circuit breaker state (0 closed, 1 open, 2 half-open).", backendLabels, circuit)
+	writeGauge(w, "proxy_backend_active_connections", "In-flight requests per backend.", backendLabels, active)
+
+	var entries, bytes, hitRatio, hits, misses, expirations, evictions, buckets []gaugeSample
+	for name, cache := range p.caches() {
+		stats := cache.Stats()
+		labels := []string{name}
+		entries = append(entries, gaugeSample{labels, float64(stats.Size)})
+		bytes = append(bytes, gaugeSample{labels, float64(stats.Bytes)})
+		hitRatio = append(hitRatio, gaugeSample{labels, stats.HitRatio()})
+		hits = append(hits, gaugeSample{labels, float64(stats.Hits)})
+		misses = append(misses, gaugeSample{labels, float64(stats.Misses)})
+		expirations = append(expirations, gaugeSample{labels, float64(stats.Expirations)})
+		evictions = append(evictions, gaugeSample{labels, float64(stats.Evictions)})
+	}
+	for name, limiter := range p.rateLimiters() {
+		count, _ := limiter.Stats()
+		buckets = append(buckets, gaugeSample{[]string{name}, float64(count)})
+	}
+	for _, samples := range [][]gaugeSample{entries, bytes, hitRatio, hits, misses, expirations, evictions, buckets} {
+		sortSamples(samples)
+	}
+
+	cacheLabels := []string{"cache"}
+	writeGauge(w, "proxy_cache_entries", "Responses currently stored in the cache.", cacheLabels, entries)
+	writeGauge(w, "proxy_cache_bytes", "Bytes of response bodies currently stored in the cache.", cacheLabels, bytes)
+	writeGauge(w, "proxy_cache_hit_ratio", "Fraction of cache lookups that were hits.", cacheLabels, hitRatio)
+	writeCounter(w, "proxy_cache_hits_total", "Cache lookups that found a fresh entry.", cacheLabels, hits)
+	writeCounter(w, "proxy_cache_misses_total", "Cache lookups that found no fresh entry.", cacheLabels, misses)
+	writeCounter(w, "proxy_cache_expirations_total", "Cache entries removed because their TTL passed.", cacheLabels, expirations)
+	writeCounter(w, "proxy_cache_evictions_total", "Cache entries evicted to make room for new ones.", cacheLabels, evictions)
+	writeGauge(w, "proxy_rate_limit_buckets", "Clients currently tracked by the rate limiter.", []string{"limiter"}, buckets)
+}
+
+// caches returns every distinct cache by name: "shared" for the top-level
+// cache and the route name for route-specific ones
+func (p *Proxy) caches() map[string]*Cache {
+	caches := make(map[string]*Cache)
+	for _, route := range p.router.Routes() {
+		switch {
+		case route.Cache == nil:
+		case route.Cache == p.cache:
+			caches["shared"] = route.Cache
+		default:
+			caches[route.Name] = route.Cache
+		}
+	}
+	return caches
+}
+
+// rateLimiters returns every distinct rate limiter by name, named like caches
+func (p *Proxy) rateLimiters() map[string]*RateLimiter {
+	limiters := make(map[string]*RateLimiter)
+	for _, route := range p.router.Routes() {
+		switch {
+		case route.RateLimiter == nil:
+		case route.RateLimiter == p.rateLimiter:
+			limiters["shared"] = route.RateLimiter
+		default:
+			limiters[route.Name] = route.RateLimiter
+		}
+	}
+	return limiters
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortSamples(samples []gaugeSample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labelValues, labelSeparator) < strings.Join(samples[j].labelValues, labelSeparator)
+	})
+}
+
+// Router returns the proxy's router
+func (p *Proxy) Router() *Router {
+	return p.router
+}
+
+// ServeHTTP handles a request through the middleware chain. Requests
+// arriving after Close are still handled, but not waited for.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.serve(w, r) {
+		p.handler.ServeHTTP(w, r)
+	}
+}
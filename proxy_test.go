@@ -2,7 +2,9 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -91,3 +93,150 @@ func TestReverseProxy_WithPort(t *testing.T) {
 
 	assert.NotNil(t, handler, "URL with port should be accepted")
 }
+
+func TestNewProxy_DefaultRoute(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	config, err := LoadTestConfig()
+	assert.NoError(t, err)
+	config.Backend = backend.URL
+	config.RequestTimeout = 5
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	assert.Len(t, proxy.Router().Routes(), 1)
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/anything", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "backend", w.Body.String())
+}
+
+func TestNewProxy_RoutesToSeparatePools(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users:" + r.URL.Path))
+	}))
+	defer users.Close()
+	orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("orders:" + r.URL.Path))
+	}))
+	defer orders.Close()
+
+	config, err := LoadTestConfig()
+	assert.NoError(t, err)
+	config.RequestTimeout = 5
+	config.Routes = []RouteConfig{
+		{Name: "users", PathPrefix: "/users", Backends: []BackendConfig{{URL: users.URL}}},
+		{Name: "orders", Host: "orders.example.com", Backends: []BackendConfig{{URL: orders.URL}}},
+	}
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/users/1", nil))
+	assert.Equal(t, "users:/users/1", w.Body.String())
+
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Host = "orders.example.com"
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, "orders:/users/1", w.Body.String())
+
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestNewProxy_InvalidRoute(t *testing.T) {
+	config := &Config{
+		RequestTimeout: 5,
+		Routes:         []RouteConfig{{Name: "empty"}},
+	}
+
+	_, err := NewProxy(config, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `route "empty"`)
+}
+
+func TestNewProxy_PerRouteMiddleware(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	enabled, disabled := true, false
+	backends := []BackendConfig{{URL: backend.URL}}
+	config := &Config{
+		RequestTimeout:   30,
+		CacheEnabled:     true,
+		CacheSize:        10,
+		CacheTTL:         60,
+		RateLimitEnabled: true,
+		RateLimitRPM:     100,
+		RateLimitBurst:   20,
+		Routes: []RouteConfig{
+			{Name: "static", PathPrefix: "/static/", Backends: backends, Cache: &RouteCacheConfig{TTLSeconds: 3600}},
+			{Name: "api", PathPrefix: "/api/", Backends: backends, Cache: &RouteCacheConfig{Enabled: &disabled}},
+			{Name: "login", Path: "/login", Backends: backends, RateLimit: &RouteRateLimitConfig{RequestsPerMinute: 5, BurstSize: 2}},
+			{Name: "stream", PathPrefix: "/stream/", Backends: backends, Timeout: &RouteTimeoutConfig{Enabled: &disabled}},
+			{Name: "public", PathPrefix: "/public/", Backends: backends, RateLimit: &RouteRateLimitConfig{Enabled: &disabled}, Timeout: &RouteTimeoutConfig{Enabled: &enabled, Seconds: 5}},
+			{Name: "default", PathPrefix: "/", Backends: backends},
+		},
+	}
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	routes := make(map[string]*Route)
+	for _, route := range proxy.Router().Routes() {
+		routes[route.Name] = route
+	}
+
+	// Routes without overrides share the top-level cache and limiter
+	assert.Same(t, proxy.cache, routes["default"].Cache)
+	assert.Same(t, proxy.rateLimiter, routes["default"].RateLimiter)
+	assert.Equal(t, 30*time.Second, routes["default"].Timeout)
+
+	assert.NotSame(t, proxy.cache, routes["static"].Cache)
+	assert.Equal(t, time.Hour, routes["static"].Cache.ttl)
+	assert.Equal(t, 10, routes["static"].Cache.capacity)
+	assert.Nil(t, routes["api"].Cache)
+	assert.Equal(t, 5, routes["login"].RateLimiter.rpm)
+	assert.Equal(t, 2, routes["login"].RateLimiter.burstSize)
+	assert.Nil(t, routes["public"].RateLimiter)
+	assert.Equal(t, 5*time.Second, routes["public"].Timeout)
+	assert.Equal(t, time.Duration(0), routes["stream"].Timeout)
+
+	// Caching applies to /static/ but not /api/
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/static/app.js", nil))
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/static/app.js", nil))
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+	assert.Empty(t, w.Header().Get("X-Cache"))
+
+	// The login route has a stricter limit than the rest of the proxy
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
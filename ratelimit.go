@@ -137,6 +137,17 @@ func (rl *RateLimiter) Cleanup(maxAge time.Duration) {
 	}
 }
 
+// Reset forgets the bucket for a key so the client starts again with a full
+// bucket, reporting whether the key had a bucket
+func (rl *RateLimiter) Reset(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	_, exists := rl.buckets[key]
+	delete(rl.buckets, key)
+	return exists
+}
+
 // Stats returns rate limiter statistics
 func (rl *RateLimiter) Stats() (buckets int, totalTokens float64) {
 	rl.mu.RLock()
@@ -189,18 +200,22 @@ func rateLimitMiddleware(limiter *RateLimiter, next http.Handler) http.Handler {
 
 		clientKey := getClientKey(r)
 
-		if !limiter.Allow(clientKey) {
+		_, span := startSpan(r.Context(), "rate limit check", SpanKindInternal)
+		allowed := limiter.Allow(clientKey)
+		span.SetAttribute("rate_limit.allowed", allowed)
+		span.End()
+
+		if !allowed {
 			// Rate limit exceeded
+			getRequestInfo(r.Context()).setRateLimited()
 			resetTime := limiter.GetResetTime(clientKey)
 
-			w.Header().Set("Content-Type", "application/json")
 			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.rpm))
 			w.Header().Set("X-RateLimit-Remaining", "0")
 			w.Header().Set("X-RateLimit-Reset", resetTime.Format(time.RFC3339))
 			w.Header().Set("Retry-After", strconv.Itoa(int(resetTime.Sub(time.Now()).Seconds())))
 
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte(`{"error":"rate_limit_exceeded","message":"Too many requests"}`))
+			writeErrorResponse(w, r, http.StatusTooManyRequests, "rate_limit_exceeded", "Too many requests")
 			return
 		}
 
@@ -322,3 +322,14 @@ func TestTokenBucket_Concurrency(t *testing.T) {
 	tokensLeft := bucket.Tokens()
 	assert.True(t, tokensLeft < 1.0, "Should have used most tokens, got %f", tokensLeft)
 }
+
+func TestRateLimiter_Reset(t *testing.T) {
+	rl := NewRateLimiter(60, 1)
+
+	assert.True(t, rl.Allow("client1"))
+	assert.False(t, rl.Allow("client1"))
+
+	assert.True(t, rl.Reset("client1"))
+	assert.False(t, rl.Reset("client1"))
+	assert.True(t, rl.Allow("client1"))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Reloader serves requests through the current Proxy and replaces it with
+// a new one when the configuration is reloaded. Requests already in flight
+// finish on the proxy they started on, which releases its resources once
+// they have.
+type Reloader struct {
+	current atomic.Pointer[Proxy]
+	load    func() (*Config, error)
+	metrics *Metrics
+	mu      sync.Mutex // serializes reloads
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewReloader creates a reloader serving proxy and building replacements
+// from the configs returned by load
+func NewReloader(proxy *Proxy, load func() (*Config, error), metrics *Metrics) *Reloader {
+	rl := &Reloader{
+		load:    load,
+		metrics: metrics,
+		stop:    make(chan struct{}),
+	}
+	rl.current.Store(proxy)
+	return rl
+}
+
+// Current returns the proxy currently serving new requests
+func (rl *Reloader) Current() *Proxy {
+	return rl.current.Load()
+}
+
+// ServeHTTP handles a request with the current proxy. A request racing a
+// reload moves on to the proxy that replaced the one closing under it.
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		p := rl.Current()
+		if p.serve(w, r) {
+			return
+		}
+		if rl.Current() == p {
+			// Stopped without a replacement
+			p.ServeHTTP(w, r)
+			return
+		}
+	}
+}
+
+// Reload loads and validates the configuration, then swaps in a proxy built
+// from it. The current proxy keeps serving if anything fails.
+func (rl *Reloader) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	config, err := rl.load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+
+	metrics := rl.metrics
+	if !config.MetricsEnabled {
+		metrics = nil
+	} else if metrics == nil {
+		slog.Warn("configuration reload: enabling metrics requires a restart")
+	}
+
+	old := rl.Current()
+	next, err := newProxy(config, metrics, old)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	warnRestartRequired(old.config, config)
+
+	next.Start()
+	rl.current.Store(next)
+	old.Close(next)
+	logLevel.Set(parseLogLevel(config.LogLevel))
+
+	slog.Info("configuration reloaded", "routes", len(next.Router().Routes()))
+	return nil
+}
+
+// WatchSignals reloads the configuration whenever the process receives SIGHUP
+func (rl *Reloader) WatchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				slog.Info("received SIGHUP, reloading configuration")
+				if err := rl.Reload(); err != nil {
+					slog.Error("configuration reload failed", "error", err)
+				}
+			case <-rl.stop:
+				return
+			}
+		}
+	}()
+}
+
+// WatchFiles polls the given files and reloads the configuration whenever
+// one of them changes
+func (rl *Reloader) WatchFiles(files []string, interval time.Duration) {
+	if len(files) == 0 || interval <= 0 {
+		return
+	}
+
+	versions := make([]fileVersion, len(files))
+	for i, file := range files {
+		versions[i] = statFile(file)
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				changed := false
+				for i, file := range files {
+					if v := statFile(file); v != versions[i] {
+						versions[i] = v
+						changed = true
+					}
+				}
+				if !changed {
+					continue
+				}
+
+				slog.Info("config file changed, reloading configuration")
+				if err := rl.Reload(); err != nil {
+					slog.Error("configuration reload failed", "error", err)
+				}
+			case <-rl.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the watchers and the background work of the current proxy, and
+// closes its access log and tracer once its in-flight requests finish
+func (rl *Reloader) Stop() {
+	rl.once.Do(func() { close(rl.stop) })
+	rl.Current().Close(nil)
+}
+
+// fileVersion identifies a version of a file by its size and modification time
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+	exists  bool
+}
+
+func statFile(name string) fileVersion {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{size: info.Size(), modTime: info.ModTime(), exists: true}
+}
+
+// warnRestartRequired logs settings that only take effect after a restart
+func warnRestartRequired(old, next *Config) {
+	if old.Port != next.Port {
+		slog.Warn("configuration reload: port change requires a restart", "port", old.Port, "new_port", next.Port)
+	}
+	if old.LogFormat != next.LogFormat {
+		slog.Warn("configuration reload: log format change requires a restart")
+	}
+	if old.AdminEnabled != next.AdminEnabled || old.AdminPort != next.AdminPort || old.AdminBindAddress != next.AdminBindAddress {
+		slog.Warn("configuration reload: admin server changes require a restart")
+	}
+	if old.TLSEnabled != next.TLSEnabled || !reflect.DeepEqual(old.TLS(), next.TLS()) ||
+		old.HTTPRedirectEnabled != next.HTTPRedirectEnabled || old.HTTPRedirectPort != next.HTTPRedirectPort {
+		slog.Warn("configuration reload: TLS listener changes require a restart")
+	}
+	if old.ACMEEnabled != next.ACMEEnabled || !reflect.DeepEqual(old.ACME(), next.ACME()) {
+		slog.Warn("configuration reload: ACME changes require a restart")
+	}
+	if old.HTTP2() != next.HTTP2() {
+		slog.Warn("configuration reload: HTTP/2 listener changes require a restart")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBackendServer returns a backend that responds with body
+func newTestBackendServer(t *testing.T, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestReloadConfig(backend string) *Config {
+	return &Config{
+		Backend:        backend,
+		RequestTimeout: 5,
+		CacheSize:      10,
+		CacheTTL:       60,
+		RateLimitRPM:   60,
+		RateLimitBurst: 10,
+	}
+}
+
+func serveBody(handler http.Handler, path string) string {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Body.String()
+}
+
+func TestReloader_SwapsProxy(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	v1 := newTestBackendServer(t, "v1")
+	v2 := newTestBackendServer(t, "v2")
+
+	proxy, err := NewProxy(newTestReloadConfig(v1.URL), nil)
+	assert.NoError(t, err)
+	reloader := NewReloader(proxy, func() (*Config, error) {
+		return newTestReloadConfig(v2.URL), nil
+	}, nil)
+	defer reloader.Stop()
+
+	assert.Equal(t, "v1", serveBody(reloader, "/"))
+	assert.NoError(t, reloader.Reload())
+	assert.NotSame(t, proxy, reloader.Current())
+	assert.Equal(t, "v2", serveBody(reloader, "/"))
+	assert.Contains(t, helper.GetLogs(), "configuration reloaded routes=1")
+}
+
+func TestReloader_InvalidConfigKeepsProxy(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	v1 := newTestBackendServer(t, "v1")
+	proxy, err := NewProxy(newTestReloadConfig(v1.URL), nil)
+	assert.NoError(t, err)
+
+	load := func() (*Config, error) { return nil, errors.New("bad file") }
+	reloader := NewReloader(proxy, load, nil)
+	err = reloader.Reload()
+	assert.ErrorContains(t, err, "bad file")
+	assert.Same(t, proxy, reloader.Current())
+
+	reloader.load = func() (*Config, error) {
+		config := newTestReloadConfig(v1.URL)
+		config.Routes = []RouteConfig{{Name: "broken", PathRegex: "("}}
+		return config, nil
+	}
+	err = reloader.Reload()
+	assert.ErrorContains(t, err, "invalid configuration")
+	assert.Same(t, proxy, reloader.Current())
+	assert.Equal(t, "v1", serveBody(reloader, "/"))
+}
+
+func TestReloader_InFlightRequestsFinishOnOldProxy(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("old"))
+	}))
+	defer slow.Close()
+	fresh := newTestBackendServer(t, "new")
+
+	proxy, err := NewProxy(newTestReloadConfig(slow.URL), nil)
+	assert.NoError(t, err)
+	reloader := NewReloader(proxy, func() (*Config, error) {
+		return newTestReloadConfig(fresh.URL), nil
+	}, nil)
+	defer reloader.Stop()
+
+	server := httptest.NewServer(reloader)
+	defer server.Close()
+
+	done := make(chan string)
+	go func() {
+		resp, err := http.Get(server.URL + "/slow")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+
+	<-started
+	assert.NoError(t, reloader.Reload())
+	assert.Equal(t, "new", serveBody(reloader, "/"))
+
+	close(release)
+	assert.Equal(t, "old", <-done)
+}
+
+func TestReloader_KeepsUnchangedCacheAndRateLimiter(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := newTestBackendServer(t, "ok")
+	config := newTestReloadConfig(backend.URL)
+	config.CacheEnabled = true
+	config.RateLimitEnabled = true
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	next := *config
+	reloader := NewReloader(proxy, func() (*Config, error) {
+		c := next
+		return &c, nil
+	}, nil)
+	defer reloader.Stop()
+
+	assert.NoError(t, reloader.Reload())
+	assert.Same(t, proxy.cache, reloader.Current().cache)
+	assert.Same(t, proxy.rateLimiter, reloader.Current().rateLimiter)
+
+	next.CacheTTL = 120
+	next.RateLimitRPM = 120
+	assert.NoError(t, reloader.Reload())
+	assert.NotSame(t, proxy.cache, reloader.Current().cache)
+	assert.Equal(t, 120*time.Second, reloader.Current().cache.ttl)
+	assert.Equal(t, 120, reloader.Current().rateLimiter.rpm)
+}
+
+func TestReloader_WatchFiles(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	v1 := newTestBackendServer(t, "v1")
+	v2 := newTestBackendServer(t, "v2")
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`{"backend":"`+v1.URL+`"}`), 0o644))
+
+	var loads int32
+	load := func() (*Config, error) {
+		atomic.AddInt32(&loads, 1)
+		config := newTestReloadConfig("")
+		return config, loadConfigFromFile(file, config)
+	}
+	config, err := load()
+	assert.NoError(t, err)
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	reloader := NewReloader(proxy, load, nil)
+	defer reloader.Stop()
+	reloader.WatchFiles([]string{file}, 10*time.Millisecond)
+
+	assert.NoError(t, os.WriteFile(file, []byte(`{"backend":"`+v2.URL+`", "port": 8081}`), 0o644))
+	assert.Eventually(t, func() bool {
+		return serveBody(reloader, "/") == "v2"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestReloader_KeepsBackendState(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	kept := newTestBackendServer(t, "kept")
+	other := newTestBackendServer(t, "other")
+	config := newTestReloadConfig("")
+	config.Backends = []BackendConfig{{URL: kept.URL}, {URL: other.URL}}
+	config.CircuitBreakerEnabled = true
+	config.CircuitBreakerConsecutiveFailures = 1
+	config.CircuitBreakerOpenTimeout = 60
+	config.OutlierDetectionEnabled = true
+	config.OutlierConsecutiveFailures = 1
+	config.OutlierBaseEjection = 60
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	backends := proxy.Router().Routes()[0].Pool.Backends()
+	backends[0].SetDraining(true)
+	backends[0].setHealthy(false)
+	backends[0].breaker.Record(false)
+	backends[0].outlier.Record(false)
+
+	next := *config
+	reloader := NewReloader(proxy, func() (*Config, error) {
+		c := next
+		return &c, nil
+	}, nil)
+	defer reloader.Stop()
+
+	// A backend with an unchanged URL keeps its state
+	next.Backends = []BackendConfig{{URL: other.URL}, {URL: kept.URL}}
+	assert.NoError(t, reloader.Reload())
+	reloaded := reloader.Current().Router().Routes()[0].Pool.Backends()
+	assert.False(t, reloaded[0].Draining())
+	assert.True(t, reloaded[1].Draining())
+	assert.False(t, reloaded[1].Healthy())
+	assert.Equal(t, CircuitOpen, reloaded[1].CircuitState())
+	assert.True(t, reloaded[1].Ejected())
+	assert.Equal(t, "other", serveBody(reloader, "/"))
+
+	// Changed circuit breaker settings start a new breaker
+	next.CircuitBreakerOpenTimeout = 30
+	assert.NoError(t, reloader.Reload())
+	reloaded = reloader.Current().Router().Routes()[0].Pool.Backends()
+	assert.True(t, reloaded[1].Draining())
+	assert.Equal(t, CircuitClosed, reloaded[1].CircuitState())
+	assert.True(t, reloaded[1].Ejected())
+}
+
+func TestReloader_ClosesOldAccessLogAfterInFlightRequests(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("old"))
+	}))
+	defer slow.Close()
+
+	dir := t.TempDir()
+	config := newTestReloadConfig(slow.URL)
+	config.AccessLogEnabled = true
+	config.AccessLogFormat = AccessLogCommon
+	config.AccessLogPath = filepath.Join(dir, "access.log")
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	reloader := NewReloader(proxy, func() (*Config, error) {
+		next := *config
+		next.AccessLogPath = filepath.Join(dir, "other.log")
+		return &next, nil
+	}, nil)
+	defer reloader.Stop()
+
+	done := make(chan string)
+	go func() { done <- serveBody(reloader, "/slow") }()
+
+	<-started
+	assert.NoError(t, reloader.Reload())
+
+	close(release)
+	assert.Equal(t, "old", <-done)
+
+	data, err := os.ReadFile(config.AccessLogPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "/slow", "the replaced proxy logs requests that outlive the reload")
+	assert.Eventually(t, func() bool {
+		_, err := proxy.accessLog.file.Write(nil)
+		return errors.Is(err, os.ErrClosed)
+	}, time.Second, 10*time.Millisecond, "the replaced access log is closed once drained")
+}
+
+func TestReloader_KeepsUnchangedAccessLog(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := newTestBackendServer(t, "ok")
+	dir := t.TempDir()
+	config := newTestReloadConfig(backend.URL)
+	config.AccessLogEnabled = true
+	config.AccessLogFormat = AccessLogCommon
+	config.AccessLogPath = filepath.Join(dir, "access.log")
+
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	next := *config
+	reloader := NewReloader(proxy, func() (*Config, error) {
+		c := next
+		return &c, nil
+	}, nil)
+	defer reloader.Stop()
+
+	assert.NoError(t, reloader.Reload())
+	assert.Same(t, proxy.accessLog, reloader.Current().accessLog)
+	serveBody(reloader, "/kept")
+
+	next.AccessLogPath = filepath.Join(dir, "other.log")
+	assert.NoError(t, reloader.Reload())
+	serveBody(reloader, "/moved")
+
+	data, err := os.ReadFile(config.AccessLogPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "/kept")
+	assert.NotContains(t, string(data), "/moved")
+	data, err = os.ReadFile(next.AccessLogPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "/moved")
+}
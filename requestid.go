@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// maxRequestIDLength bounds incoming request IDs so a client cannot bloat
+// every log line and upstream request
+const maxRequestIDLength = 128
+
+// RequestIDConfig holds the request ID settings
+type RequestIDConfig struct {
+	Header  string         // header carrying the ID, such as X-Request-ID
+	Trusted []netip.Prefix // clients whose incoming IDs are kept
+}
+
+// trusts reports whether an incoming request ID from the client at
+// remoteAddr should be kept
+func (c RequestIDConfig) trusts(remoteAddr string) bool {
+	if len(c.Trusted) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range c.Trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// validRequestID reports whether id is short and made of visible ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware gives every request an ID, keeping a valid incoming
+// one from trusted clients. The ID is forwarded upstream in the request
+// header and echoed in the response header.
+func requestIDMiddleware(config RequestIDConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r)
+		if incoming := r.Header.Get(config.Header); validRequestID(incoming) && config.trusts(r.RemoteAddr) {
+			info.setID(incoming)
+		}
+
+		id := info.snapshot().ID
+		r.Header.Set(config.Header, id)
+		next.ServeHTTP(&requestIDResponseWriter{ResponseWriter: w, header: config.Header, id: id}, r)
+	})
+}
+
+// requestIDResponseWriter sets the request ID header when the response
+// headers are written, replacing any copy from a backend or the cache
+type requestIDResponseWriter struct {
+	http.ResponseWriter
+	header      string
+	id          string
+	wroteHeader bool
+}
+
+func (w *requestIDResponseWriter) WriteHeader(code int) {
+	w.Header().Set(w.header, w.id)
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *requestIDResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the headers, including the request ID, and buffered data to
+// the client
+func (w *requestIDResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	flush(w.ResponseWriter)
+}
+
+// Hijack sets the request ID header, which is sent with the protocol switch
+// response, and hands the connection to the handler
+func (w *requestIDResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.Header().Set(w.header, w.id)
+	return hijack(w.ResponseWriter)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (w *requestIDResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
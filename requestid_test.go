@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newRequestIDTestProxy returns a proxy in front of a backend that echoes the
+// request ID it receives, both in the body and in its response header
+func newRequestIDTestProxy(t *testing.T, trusted ...string) *Proxy {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", r.Header.Get("X-Request-ID"))
+		w.Write([]byte(r.Header.Get("X-Request-ID")))
+	}))
+	t.Cleanup(backend.Close)
+
+	config := newTestReloadConfig(backend.URL)
+	config.RequestIDHeader = "X-Request-ID"
+	config.RequestIDTrustedNetworks = trusted
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	return proxy
+}
+
+func TestRequestID_GeneratedForwardedAndEchoed(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	proxy := newRequestIDTestProxy(t)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	id := w.Header().Get("X-Request-ID")
+	assert.Len(t, id, 32)
+	assert.Equal(t, []string{id}, w.Header().Values("X-Request-ID"), "the backend's copy is replaced")
+	assert.Equal(t, id, w.Body.String(), "the backend receives the same ID")
+	assert.Contains(t, helper.GetLogs(), "request_id="+id)
+}
+
+func TestRequestID_IncomingFromUntrustedClientReplaced(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	proxy := newRequestIDTestProxy(t, "10.0.0.0/8")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	req.Header.Set("X-Request-ID", "client-chosen")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	assert.NotEqual(t, "client-chosen", w.Header().Get("X-Request-ID"))
+	assert.NotEqual(t, "client-chosen", w.Body.String())
+}
+
+func TestRequestID_IncomingFromTrustedClientKept(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	proxy := newRequestIDTestProxy(t, "10.0.0.0/8")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set("X-Request-ID", "lb-1234")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	assert.Equal(t, "lb-1234", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "lb-1234", w.Body.String())
+
+	req.Header.Set("X-Request-ID", "has spaces")
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Len(t, w.Header().Get("X-Request-ID"), 32, "invalid IDs are replaced even from trusted clients")
+}
+
+func TestRequestID_CachedResponseGetsNewID(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := newTestBackendServer(t, "ok")
+	config := newTestReloadConfig(backend.URL)
+	config.RequestIDHeader = "X-Request-ID"
+	config.CacheEnabled = true
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	first := httptest.NewRecorder()
+	proxy.ServeHTTP(first, httptest.NewRequest("GET", "/cached", nil))
+	second := httptest.NewRecorder()
+	proxy.ServeHTTP(second, httptest.NewRequest("GET", "/cached", nil))
+
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Len(t, second.Header().Values("X-Request-ID"), 1)
+	assert.NotEqual(t, first.Header().Get("X-Request-ID"), second.Header().Get("X-Request-ID"))
+}
+
+func TestRequestID_InErrorResponse(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	config := newTestReloadConfig("http://127.0.0.1:1")
+	config.RequestIDHeader = "X-Request-ID"
+	config.Routes = []RouteConfig{{Name: "api", PathPrefix: "/api", Backends: []BackendConfig{{URL: "http://127.0.0.1:1"}}}}
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	for _, path := range []string{"/missing", "/api/users"} {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		var body ErrorResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.NotEmpty(t, body.RequestID, path)
+		assert.Equal(t, w.Header().Get("X-Request-ID"), body.RequestID, path)
+	}
+	assert.Contains(t, helper.GetLogs(), "proxy error request_id=")
+}
+
+func TestRequestIDConfig_Trusts(t *testing.T) {
+	config := RequestIDConfig{Trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}}
+
+	assert.True(t, config.trusts("10.1.2.3:5000"))
+	assert.True(t, config.trusts("[::ffff:10.1.2.3]:5000"))
+	assert.True(t, config.trusts("[::1]:5000"))
+	assert.False(t, config.trusts("192.0.2.1:5000"))
+	assert.False(t, config.trusts("not-an-ip"))
+	assert.False(t, RequestIDConfig{}.trusts("10.1.2.3:5000"))
+}
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, validRequestID("3f9c2a7b-6d1e-4f80"))
+	assert.False(t, validRequestID(""))
+	assert.False(t, validRequestID("has space"))
+	assert.False(t, validRequestID("line\nbreak"))
+	assert.False(t, validRequestID(strings.Repeat("a", maxRequestIDLength+1)))
+}
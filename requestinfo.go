@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestInfoKey is the context key for a request's requestInfo
+type requestInfoKey struct{}
+
+// upstreamError records a failed attempt to reach a backend
+type upstreamError struct {
+	Backend string
+	Reason  string
+}
+
+// requestInfo collects details about a request as it moves through the
+// middleware chain, for use by logging and metrics. Its methods are safe to
+// call on a nil receiver, so handlers can record details whether or not an
+// outer middleware attached one.
+type requestInfo struct {
+	mu             sync.Mutex
+	id             string
+	route          string
+	backend        string
+	latency        time.Duration
+	cacheStatus    string
+	rateLimited    bool
+	upstreamErrors []upstreamError
+}
+
+// withRequestInfo returns the request's requestInfo, attaching a new one
+// to the request context if there is none yet
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	if info := getRequestInfo(r.Context()); info != nil {
+		return r, info
+	}
+
+	info := &requestInfo{id: newRequestID()}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+// newRequestID returns a random 128-bit identifier in hex
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// getRequestInfo returns the requestInfo attached to the context, or nil
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+func (ri *requestInfo) setRoute(route string) {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.route = route
+}
+
+func (ri *requestInfo) setBackend(backend string) {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.backend = backend
+}
+
+// setID replaces the generated request ID, such as with a trusted incoming one
+func (ri *requestInfo) setID(id string) {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.id = id
+}
+
+// setUpstreamLatency records how long the last backend took to respond
+func (ri *requestInfo) setUpstreamLatency(latency time.Duration) {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.latency = latency
+}
+
+func (ri *requestInfo) setCacheStatus(status string) {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.cacheStatus = status
+}
+
+func (ri *requestInfo) setRateLimited() {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.rateLimited = true
+}
+
+func (ri *requestInfo) addUpstreamError(backend, reason string) {
+	if ri == nil {
+		return
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.upstreamErrors = append(ri.upstreamErrors, upstreamError{Backend: backend, Reason: reason})
+}
+
+// requestInfoSnapshot is a copy of a requestInfo's fields
+type requestInfoSnapshot struct {
+	ID              string
+	Route           string
+	Backend         string
+	UpstreamLatency time.Duration
+	CacheStatus     string
+	RateLimited     bool
+	UpstreamErrors  []upstreamError
+}
+
+// snapshot returns a consistent copy of the collected details
+func (ri *requestInfo) snapshot() requestInfoSnapshot {
+	if ri == nil {
+		return requestInfoSnapshot{}
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	return requestInfoSnapshot{
+		ID:              ri.id,
+		Route:           ri.route,
+		Backend:         ri.backend,
+		UpstreamLatency: ri.latency,
+		CacheStatus:     ri.cacheStatus,
+		RateLimited:     ri.rateLimited,
+		UpstreamErrors:  append([]upstreamError(nil), ri.upstreamErrors...),
+	}
+}
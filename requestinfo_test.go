@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRequestInfo_ReusesExisting(t *testing.T) {
+	r, info := withRequestInfo(httptest.NewRequest("GET", "/", nil))
+	assert.NotNil(t, info)
+
+	r2, info2 := withRequestInfo(r)
+	assert.Same(t, r, r2)
+	assert.Same(t, info, info2)
+	assert.Same(t, info, getRequestInfo(r.Context()))
+
+	_, other := withRequestInfo(httptest.NewRequest("GET", "/", nil))
+	assert.NotEqual(t, info.snapshot().ID, other.snapshot().ID)
+}
+
+func TestRequestInfo_Snapshot(t *testing.T) {
+	_, info := withRequestInfo(httptest.NewRequest("GET", "/", nil))
+	info.setRoute("api")
+	info.setBackend("backend:80")
+	info.setCacheStatus("HIT")
+	info.setRateLimited()
+	info.addUpstreamError("backend:80", "timeout")
+
+	snapshot := info.snapshot()
+	assert.Len(t, snapshot.ID, 32)
+	assert.Equal(t, "api", snapshot.Route)
+	assert.Equal(t, "backend:80", snapshot.Backend)
+	assert.Equal(t, "HIT", snapshot.CacheStatus)
+	assert.True(t, snapshot.RateLimited)
+	assert.Equal(t, []upstreamError{{Backend: "backend:80", Reason: "timeout"}}, snapshot.UpstreamErrors)
+}
+
+func TestRequestInfo_NilIsSafe(t *testing.T) {
+	info := getRequestInfo(context.Background())
+	assert.Nil(t, info)
+
+	info.setRoute("api")
+	info.addUpstreamError("backend:80", "timeout")
+	assert.Equal(t, requestInfoSnapshot{}, info.snapshot())
+}
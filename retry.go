@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// retryBudgetBuckets is the number of one-second buckets the retry budget covers
+const retryBudgetBuckets = 10
+
+// RetryConfig holds the settings for retrying failed upstream requests
+type RetryConfig struct {
+	MaxAttempts        int           // total attempts including the first one
+	RetryOnStatus      []int         // response statuses that trigger a retry
+	BackoffBase        time.Duration // backoff before the first retry
+	BackoffMax         time.Duration // cap for the exponential backoff
+	BudgetPercent      int           // retries allowed as a percentage of requests
+	BudgetMinPerSecond int           // retries always allowed regardless of traffic
+	MaxBodyBytes       int64         // largest request body buffered for replay
+}
+
+// idempotentMethods can be retried safely without an Idempotency-Key
+var idempotentMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodPut,
+	http.MethodDelete,
+	http.MethodTrace,
+}
+
+// isIdempotent reports whether a request may be sent more than once
+func isIdempotent(req *http.Request) bool {
+	return slices.Contains(idempotentMethods, req.Method) || req.Header.Get("Idempotency-Key") != ""
+}
+
+// budgetBucket counts requests and retries over one second
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+// RetryBudget caps retries to a share of live traffic over a rolling window
+// so that retries cannot amplify an outage
+type RetryBudget struct {
+	percent      int
+	minPerSecond int
+	buckets      [retryBudgetBuckets]budgetBucket
+	mu           sync.Mutex
+	now          func() time.Time
+}
+
+// NewRetryBudget creates a retry budget
+func NewRetryBudget(percent, minPerSecond int) *RetryBudget {
+	return &RetryBudget{
+		percent:      percent,
+		minPerSecond: minPerSecond,
+		now:          time.Now,
+	}
+}
+
+// RecordRequest counts an incoming request towards the budget
+func (rb *RetryBudget) RecordRequest() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.bucket(rb.now()).requests++
+}
+
+// TryRetry reports whether a retry fits in the budget, consuming it if so
+func (rb *RetryBudget) TryRetry() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	now := rb.now()
+	requests, retries := 0, 0
+	for _, bucket := range rb.buckets {
+		if now.Unix()-bucket.second < retryBudgetBuckets {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	allowed := rb.minPerSecond*retryBudgetBuckets + requests*rb.percent/100
+	if retries >= allowed {
+		return false
+	}
+
+	rb.bucket(now).retries++
+	return true
+}
+
+// bucket returns the bucket for the current second, resetting it if stale
+func (rb *RetryBudget) bucket(now time.Time) *budgetBucket {
+	second := now.Unix()
+	bucket := &rb.buckets[second%retryBudgetBuckets]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
+
+// retrier replays failed requests on other backends of a pool
+type retrier struct {
+	config RetryConfig
+	budget *RetryBudget
+}
+
+func newRetrier(config RetryConfig) *retrier {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 1
+	}
+
+	return &retrier{
+		config: config,
+		budget: NewRetryBudget(config.BudgetPercent, config.BudgetMinPerSecond),
+	}
+}
+
+// shouldRetry reports whether an attempt's outcome warrants another attempt
+func (rt *retrier) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return slices.Contains(rt.config.RetryOnStatus, resp.StatusCode)
+}
+
+// backoff returns the delay before the given retry, using exponential
+// backoff with full jitter
+func (rt *retrier) backoff(retry int) time.Duration {
+	limit := rt.config.BackoffBase << (retry - 1)
+	if limit > rt.config.BackoffMax || limit <= 0 {
+		limit = rt.config.BackoffMax
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(limit))) + 1
+}
+
+// wait sleeps for the backoff unless the request is cancelled first
+func (rt *retrier) wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(rt.backoff(retry))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bufferRequestBody reads the request body into memory so it can be
+// replayed. It reports false, leaving the body intact, when the body is
+// larger than the limit.
+func bufferRequestBody(req *http.Request, limit int64) (bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return false, err
+	}
+
+	if int64(len(buf)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return false, nil
+	}
+
+	req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:        3,
+		RetryOnStatus:      []int{502, 503, 504},
+		BackoffBase:        time.Millisecond,
+		BackoffMax:         5 * time.Millisecond,
+		BudgetPercent:      20,
+		BudgetMinPerSecond: 10,
+		MaxBodyBytes:       1024,
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	for _, method := range []string{"GET", "HEAD", "OPTIONS", "PUT", "DELETE"} {
+		assert.True(t, isIdempotent(httptest.NewRequest(method, "/", nil)), method)
+	}
+
+	post := httptest.NewRequest("POST", "/", nil)
+	assert.False(t, isIdempotent(post))
+	post.Header.Set("Idempotency-Key", "abc-123")
+	assert.True(t, isIdempotent(post))
+}
+
+func TestRetryBudget_LimitsRetries(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	budget := NewRetryBudget(10, 0)
+	budget.now = clock.Now
+
+	for i := 0; i < 50; i++ {
+		budget.RecordRequest()
+	}
+
+	// 10% of 50 requests
+	for i := 0; i < 5; i++ {
+		assert.True(t, budget.TryRetry(), "retry %d should fit in the budget", i+1)
+	}
+	assert.False(t, budget.TryRetry())
+
+	// Once the window has passed the budget is replenished by new traffic only
+	clock.Advance(11 * time.Second)
+	assert.False(t, budget.TryRetry())
+	for i := 0; i < 10; i++ {
+		budget.RecordRequest()
+	}
+	assert.True(t, budget.TryRetry())
+}
+
+func TestRetryBudget_MinimumPerSecond(t *testing.T) {
+	budget := NewRetryBudget(0, 1)
+
+	// A minimum of one retry per second across the ten second window
+	for i := 0; i < 10; i++ {
+		assert.True(t, budget.TryRetry())
+	}
+	assert.False(t, budget.TryRetry())
+}
+
+func TestRetrier_BackoffIsBounded(t *testing.T) {
+	rt := newRetrier(RetryConfig{BackoffBase: 10 * time.Millisecond, BackoffMax: 50 * time.Millisecond})
+
+	for retry := 1; retry <= 10; retry++ {
+		d := rt.backoff(retry)
+		assert.True(t, d > 0 && d <= 50*time.Millisecond, "backoff %v out of range", d)
+	}
+	for i := 0; i < 20; i++ {
+		assert.LessOrEqual(t, rt.backoff(1), 10*time.Millisecond)
+	}
+}
+
+func TestBufferRequestBody(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/", strings.NewReader("payload"))
+	ok, err := bufferRequestBody(req, 1024)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	first, _ := io.ReadAll(req.Body)
+	replay, _ := req.GetBody()
+	second, _ := io.ReadAll(replay)
+	assert.Equal(t, "payload", string(first))
+	assert.Equal(t, "payload", string(second))
+}
+
+func TestBufferRequestBody_TooLarge(t *testing.T) {
+	req := httptest.NewRequest("PUT", "/", strings.NewReader("0123456789"))
+	ok, err := bufferRequestBody(req, 4)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// The body is still complete for the single attempt
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, "0123456789", string(body))
+}
+
+func TestBackendPool_RetriesOnAnotherBackend(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	var failingHits, goodHits int32
+	var goodBody string
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failingHits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&goodHits, 1)
+		body, _ := io.ReadAll(r.Body)
+		goodBody = string(body)
+		w.Write([]byte("ok"))
+	}))
+	defer good.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: failing.URL}, {URL: good.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	pool.EnableRetries(newTestRetryConfig())
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest("PUT", "/item", strings.NewReader("data")))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failingHits))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&goodHits))
+	assert.Equal(t, "data", goodBody, "the body is replayed on retry")
+	assert.Contains(t, helper.GetLogs(), "retrying request")
+	assert.Contains(t, helper.GetLogs(), "method=PUT path=/item")
+}
+
+func TestBackendPool_RetriesConnectionErrors(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer good.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: "http://127.0.0.1:0"}, {URL: good.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	pool.EnableRetries(newTestRetryConfig())
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestBackendPool_DoesNotRetryNonIdempotent(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	var hits int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: failing.URL}, {URL: failing.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	pool.EnableRetries(newTestRetryConfig())
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader("x")))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	// The same request with an Idempotency-Key is retried
+	req := httptest.NewRequest("POST", "/", strings.NewReader("x"))
+	req.Header.Set("Idempotency-Key", "key-1")
+	pool.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&hits))
+}
+
+func TestBackendPool_StopsAtMaxAttempts(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	var hits int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer failing.Close()
+
+	pool, err := NewBackendPool([]BackendConfig{{URL: failing.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+	pool.EnableRetries(newTestRetryConfig())
+
+	w := httptest.NewRecorder()
+	pool.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+}
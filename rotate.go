@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile is an append-only log file that is rotated once it reaches a
+// maximum size or age. Rotated files are renamed with a timestamp suffix and
+// the oldest are removed beyond maxBackups.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64         // 0 disables size-based rotation
+	interval   time.Duration // 0 disables time-based rotation
+	maxBackups int           // 0 keeps every rotated file
+	file       *os.File      // nil after Close, or if reopening after a failed rotation failed
+	closed     bool
+	failed     bool // the last rotation failed and was reported
+	size       int64
+	opened     time.Time
+	now        func() time.Time
+}
+
+// backupTimeFormat sorts rotated files in the order they were rotated
+const backupTimeFormat = "20060102T150405.000"
+
+// openRotatingFile opens path for appending, creating it if needed
+func openRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = f.now()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past its size
+// limit or the file is older than the rotation interval. A failed rotation
+// is logged once and writing continues to the current file.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.file != nil && f.shouldRotate(int64(len(p))) {
+		err := f.rotate()
+		if err != nil && !f.failed {
+			slog.Error("log file rotation failed", "path", f.path, "error", err)
+		}
+		f.failed = err != nil
+	}
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) shouldRotate(next int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+next > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && !f.now().Before(f.opened.Add(f.interval))
+}
+
+// rotate renames the current file aside and starts a new one. If either
+// step fails, the file at path is reopened for appending.
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err == nil {
+		backup := fmt.Sprintf("%s.%s", f.path, f.now().Format(backupTimeFormat))
+		err = os.Rename(f.path, backup)
+	}
+	if err != nil {
+		return errors.Join(err, f.open())
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.removeOldBackups()
+	return nil
+}
+
+// removeOldBackups deletes the oldest rotated files beyond maxBackups
+func (f *rotatingFile) removeOldBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	matches, _ := filepath.Glob(f.path + ".*")
+	var backups []string
+	for _, match := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(match, f.path+".")); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	if len(backups) <= f.maxBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-f.maxBackups] {
+		os.Remove(backup)
+	}
+}
+
+// Close closes the file; later writes fail with os.ErrClosed
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotatingFile(path, 10, 0, 0)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	clock := &fakeClock{now: time.Now()}
+	f.now = clock.Now
+
+	f.Write([]byte("12345678\n"))
+	clock.Advance(time.Second)
+	f.Write([]byte("abcdefgh\n"))
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "abcdefgh\n", string(data))
+
+	backups, _ := filepath.Glob(path + ".*")
+	assert.Len(t, backups, 1)
+	data, _ = os.ReadFile(backups[0])
+	assert.Equal(t, "12345678\n", string(data))
+}
+
+func TestRotatingFile_RotatesByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	clock := &fakeClock{now: time.Now()}
+	f, err := openRotatingFile(path, 0, time.Hour, 0)
+	assert.NoError(t, err)
+	defer f.Close()
+	f.now = clock.Now
+	f.opened = clock.Now()
+
+	f.Write([]byte("first\n"))
+	clock.Advance(30 * time.Minute)
+	f.Write([]byte("second\n"))
+	backups, _ := filepath.Glob(path + ".*")
+	assert.Empty(t, backups)
+
+	clock.Advance(30 * time.Minute)
+	f.Write([]byte("third\n"))
+	backups, _ = filepath.Glob(path + ".*")
+	assert.Len(t, backups, 1)
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "third\n", string(data))
+}
+
+func TestRotatingFile_KeepsMaxBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	assert.NoError(t, os.WriteFile(path+".unrelated", []byte("keep"), 0o644))
+
+	f, err := openRotatingFile(path, 5, 0, 2)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	clock := &fakeClock{now: time.Now()}
+	f.now = clock.Now
+	for i := 0; i < 5; i++ {
+		f.Write([]byte("line\n"))
+		clock.Advance(time.Second)
+	}
+
+	matches, _ := filepath.Glob(path + ".*")
+	assert.Len(t, matches, 3, "two backups plus the unrelated file")
+	assert.FileExists(t, path+".unrelated")
+}
+
+func TestRotatingFile_AppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	assert.NoError(t, os.WriteFile(path, []byte("old\n"), 0o644))
+
+	f, err := openRotatingFile(path, 0, 0, 0)
+	assert.NoError(t, err)
+	f.Write([]byte("new\n"))
+	assert.NoError(t, f.Close())
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "old\nnew\n", string(data))
+
+	_, err = f.Write([]byte("closed\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestRotatingFile_KeepsWritingWhenRotationFails(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotatingFile(path, 10, 0, 0)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	// A non-empty directory in the way makes renaming the file fail
+	clock := &fakeClock{now: time.Now()}
+	f.now = clock.Now
+	backup := path + "." + clock.Now().Format(backupTimeFormat)
+	assert.NoError(t, os.MkdirAll(filepath.Join(backup, "blocker"), 0o755))
+
+	for _, line := range []string{"12345678\n", "abcdefgh\n", "ijklmnop\n"} {
+		_, err := f.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "12345678\nabcdefgh\nijklmnop\n", string(data))
+	assert.Equal(t, 1, strings.Count(helper.GetLogs(), "log file rotation failed"), "the failure is reported once")
+
+	// Rotation resumes once the problem is fixed
+	assert.NoError(t, os.RemoveAll(backup))
+	f.Write([]byte("qrstuvwx\n"))
+	data, _ = os.ReadFile(path)
+	assert.Equal(t, "qrstuvwx\n", string(data))
+	data, _ = os.ReadFile(backup)
+	assert.Equal(t, "12345678\nabcdefgh\nijklmnop\n", string(data))
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RouteConfig describes a routing rule and the backend pool it forwards to.
+// All matchers that are set must match for the route to apply.
+type RouteConfig struct {
+	Name         string            `json:"name"`
+	Host         string            `json:"host"`        // exact host or wildcard such as "*.example.com"
+	Path         string            `json:"path"`        // exact path
+	PathPrefix   string            `json:"path_prefix"` // path prefix
+	PathRegex    string            `json:"path_regex"`  // regular expression matched against the path
+	Methods      []string          `json:"methods"`
+	Headers      map[string]string `json:"headers"`      // header values to match; an empty value only requires presence
+	GRPCService  string            `json:"grpc_service"` // fully-qualified gRPC service such as "package.Service"
+	GRPCMethod   string            `json:"grpc_method"`  // method of grpc_service; empty matches every method
+	Priority     int               `json:"priority"`
+	Backends     []BackendConfig   `json:"backends"`
+	LoadBalancer string            `json:"load_balancer"`
+	HashKey      string            `json:"hash_key"`
+
+	// ClientCertRequired rejects requests without a verified client
+	// certificate; tls_client_auth must be optional or require
+	ClientCertRequired bool `json:"client_cert_required"`
+
+	// Middleware overrides; when unset the top-level settings apply
+	Cache     *RouteCacheConfig     `json:"cache"`
+	RateLimit *RouteRateLimitConfig `json:"rate_limit"`
+	Timeout   *RouteTimeoutConfig   `json:"timeout"`
+}
+
+// RouteCacheConfig overrides the caching settings for a route. Zero values
+// fall back to the top-level cache settings.
+type RouteCacheConfig struct {
+	Enabled    *bool `json:"enabled"`
+	Size       int   `json:"size"`
+	TTLSeconds int   `json:"ttl_seconds"`
+}
+
+// RouteRateLimitConfig overrides the rate limiting settings for a route.
+// Zero values fall back to the top-level rate limit settings.
+type RouteRateLimitConfig struct {
+	Enabled           *bool `json:"enabled"`
+	RequestsPerMinute int   `json:"requests_per_minute"`
+	BurstSize         int   `json:"burst_size"`
+}
+
+// RouteTimeoutConfig overrides the request and stream idle timeouts for a
+// route. Zero values fall back to the top-level timeouts.
+type RouteTimeoutConfig struct {
+	Enabled           *bool `json:"enabled"`
+	Seconds           int   `json:"seconds"`
+	StreamIdleSeconds int   `json:"stream_idle_seconds"`
+}
+
+// Route is a compiled routing rule
+type Route struct {
+	Name        string
+	Pool        *BackendPool
+	Cache       *Cache
+	RateLimiter *RateLimiter
+	Timeout     time.Duration
+	StreamIdle  time.Duration
+	config      RouteConfig
+	regex       *regexp.Regexp
+	order       int
+	next        http.Handler
+}
+
+// NewRoute compiles a route forwarding matching requests to next
+func NewRoute(config RouteConfig, next http.Handler) (*Route, error) {
+	route := &Route{
+		Name:   config.Name,
+		config: config,
+		next:   next,
+	}
+
+	if config.PathRegex != "" {
+		regex, err := regexp.Compile(config.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: invalid path_regex: %v", config.Name, err)
+		}
+		route.regex = regex
+	}
+
+	return route, nil
+}
+
+// Matches reports whether the request satisfies every matcher of the route
+func (rt *Route) Matches(r *http.Request) bool {
+	c := rt.config
+
+	if c.Host != "" && !matchHost(c.Host, requestHost(r)) {
+		return false
+	}
+	if c.Path != "" && r.URL.Path != c.Path {
+		return false
+	}
+	if c.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, c.PathPrefix) {
+		return false
+	}
+	if rt.regex != nil && !rt.regex.MatchString(r.URL.Path) {
+		return false
+	}
+	if c.GRPCService != "" {
+		path, exact := grpcRoutePath(c.GRPCService, c.GRPCMethod)
+		if !isGRPCRequest(r) || (exact && r.URL.Path != path) || (!exact && !strings.HasPrefix(r.URL.Path, path)) {
+			return false
+		}
+	}
+	if len(c.Methods) > 0 && !slices.ContainsFunc(c.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) }) {
+		return false
+	}
+	for name, value := range c.Headers {
+		values, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok || (value != "" && !slices.Contains(values, value)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// moreSpecificThan orders routes by priority, then host specificity, then
+// path specificity (exact, longest prefix, regex), then number of other
+// matchers, and finally by the order they were configured in
+func (rt *Route) moreSpecificThan(other *Route) bool {
+	if rt.config.Priority != other.config.Priority {
+		return rt.config.Priority > other.config.Priority
+	}
+	if a, b := hostSpecificity(rt.config.Host), hostSpecificity(other.config.Host); a != b {
+		return a > b
+	}
+	if a, b := rt.pathSpecificity(), other.pathSpecificity(); a != b {
+		return a > b
+	}
+	if a, b := len(rt.config.Methods)+len(rt.config.Headers), len(other.config.Methods)+len(other.config.Headers); a != b {
+		return a > b
+	}
+	return rt.order < other.order
+}
+
+// pathSpecificity ranks exact paths above prefixes (longest first) and
+// prefixes above regular expressions. A gRPC method counts as an exact path
+// and a gRPC service as a prefix.
+func (rt *Route) pathSpecificity() int {
+	switch {
+	case rt.config.Path != "" || rt.config.GRPCMethod != "":
+		return 1 << 20
+	case rt.config.PathPrefix != "":
+		return 2 + len(rt.config.PathPrefix)
+	case rt.config.GRPCService != "":
+		path, _ := grpcRoutePath(rt.config.GRPCService, "")
+		return 2 + len(path)
+	case rt.regex != nil:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// hostSpecificity ranks exact hosts above wildcards, longer wildcards first
+func hostSpecificity(pattern string) int {
+	switch {
+	case pattern == "":
+		return 0
+	case strings.HasPrefix(pattern, "*."):
+		return len(pattern)
+	default:
+		return 1 << 20
+	}
+}
+
+// matchHost matches a host against an exact or "*.domain" wildcard pattern
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+// requestHost returns the lower-cased request host without its port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// Router dispatches requests to the most specific matching route
+type Router struct {
+	routes []*Route
+}
+
+// NewRouter creates a router, ordering routes from most to least specific
+func NewRouter(routes []*Route) *Router {
+	sorted := make([]*Route, len(routes))
+	copy(sorted, routes)
+	for i, route := range sorted {
+		route.order = i
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].moreSpecificThan(sorted[j])
+	})
+
+	return &Router{routes: sorted}
+}
+
+// Routes returns the routes from most to least specific
+func (rt *Router) Routes() []*Route {
+	return rt.routes
+}
+
+// Match returns the route for the request, or nil if none matches
+func (rt *Router) Match(r *http.Request) *Route {
+	for _, route := range rt.routes {
+		if route.Matches(r) {
+			return route
+		}
+	}
+	return nil
+}
+
+// ServeHTTP forwards the request to its route or responds with 404
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route := rt.Match(r)
+	if route == nil {
+		writeErrorResponse(w, r, http.StatusNotFound, "route_not_found", "No route matches the request")
+		return
+	}
+
+	getRequestInfo(r.Context()).setRoute(route.Name)
+	route.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// namedHandler responds with its name so tests can tell routes apart
+func namedHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	})
+}
+
+func newTestRouter(t *testing.T, configs ...RouteConfig) *Router {
+	var routes []*Route
+	for _, config := range configs {
+		route, err := NewRoute(config, namedHandler(config.Name))
+		assert.NoError(t, err)
+		routes = append(routes, route)
+	}
+	return NewRouter(routes)
+}
+
+func routeName(router *Router, r *http.Request) string {
+	if route := router.Match(r); route != nil {
+		return route.Name
+	}
+	return ""
+}
+
+func TestNewRoute_InvalidRegex(t *testing.T) {
+	_, err := NewRoute(RouteConfig{Name: "bad", PathRegex: "("}, namedHandler("bad"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid path_regex")
+}
+
+func TestRouter_HostMatching(t *testing.T) {
+	router := newTestRouter(t,
+		RouteConfig{Name: "exact", Host: "api.example.com"},
+		RouteConfig{Name: "wildcard", Host: "*.example.com"},
+		RouteConfig{Name: "catch-all"},
+	)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "API.example.com:8080"
+	assert.Equal(t, "exact", routeName(router, req))
+
+	req.Host = "shop.example.com"
+	assert.Equal(t, "wildcard", routeName(router, req))
+
+	req.Host = "deep.shop.example.com"
+	assert.Equal(t, "wildcard", routeName(router, req))
+
+	req.Host = "example.com"
+	assert.Equal(t, "catch-all", routeName(router, req))
+}
+
+func TestRouter_PathMatching(t *testing.T) {
+	router := newTestRouter(t,
+		RouteConfig{Name: "api", PathPrefix: "/api/"},
+		RouteConfig{Name: "api-users", PathPrefix: "/api/users"},
+		RouteConfig{Name: "login", Path: "/api/users/login"},
+		RouteConfig{Name: "images", PathRegex: `\.(png|jpg)$`},
+	)
+
+	assert.Equal(t, "api", routeName(router, httptest.NewRequest("GET", "/api/orders", nil)))
+	assert.Equal(t, "api-users", routeName(router, httptest.NewRequest("GET", "/api/users/7", nil)))
+	assert.Equal(t, "login", routeName(router, httptest.NewRequest("GET", "/api/users/login", nil)))
+	assert.Equal(t, "images", routeName(router, httptest.NewRequest("GET", "/static/logo.png", nil)))
+	assert.Equal(t, "", routeName(router, httptest.NewRequest("GET", "/other", nil)))
+}
+
+func TestRouter_MethodAndHeaderMatching(t *testing.T) {
+	router := newTestRouter(t,
+		RouteConfig{Name: "writes", PathPrefix: "/", Methods: []string{"post", "PUT"}},
+		RouteConfig{Name: "beta", PathPrefix: "/", Headers: map[string]string{"X-Beta": "1"}},
+		RouteConfig{Name: "traced", PathPrefix: "/", Headers: map[string]string{"X-Trace": ""}},
+		RouteConfig{Name: "default", PathPrefix: "/"},
+	)
+
+	assert.Equal(t, "writes", routeName(router, httptest.NewRequest("POST", "/", nil)))
+	assert.Equal(t, "default", routeName(router, httptest.NewRequest("GET", "/", nil)))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Beta", "1")
+	assert.Equal(t, "beta", routeName(router, req))
+
+	req.Header.Set("X-Beta", "0")
+	assert.Equal(t, "default", routeName(router, req))
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Trace", "anything")
+	assert.Equal(t, "traced", routeName(router, req))
+}
+
+func TestRouter_PriorityOverridesSpecificity(t *testing.T) {
+	router := newTestRouter(t,
+		RouteConfig{Name: "specific", Path: "/maintenance/status"},
+		RouteConfig{Name: "maintenance", PathPrefix: "/", Priority: 10},
+	)
+
+	assert.Equal(t, "maintenance", routeName(router, httptest.NewRequest("GET", "/maintenance/status", nil)))
+}
+
+func TestRouter_HostBeforePath(t *testing.T) {
+	router := newTestRouter(t,
+		RouteConfig{Name: "long-path", PathPrefix: "/api/v1/"},
+		RouteConfig{Name: "host", Host: "api.example.com"},
+	)
+
+	req := httptest.NewRequest("GET", "/api/v1/items", nil)
+	req.Host = "api.example.com"
+	assert.Equal(t, "host", routeName(router, req))
+}
+
+func TestRouter_ConfigurationOrderBreaksTies(t *testing.T) {
+	router := newTestRouter(t,
+		RouteConfig{Name: "first", PathPrefix: "/a"},
+		RouteConfig{Name: "second", PathPrefix: "/b"},
+		RouteConfig{Name: "third", PathPrefix: "/a"},
+	)
+
+	assert.Equal(t, "first", routeName(router, httptest.NewRequest("GET", "/a", nil)))
+}
+
+func TestRouter_ServeHTTP(t *testing.T) {
+	router := newTestRouter(t, RouteConfig{Name: "api", PathPrefix: "/api"})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/items", nil))
+	assert.Equal(t, "api", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var errResp ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
+	assert.Equal(t, "route_not_found", errResp.Error)
+	assert.Equal(t, http.StatusNotFound, errResp.Code)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// isEventStream reports whether the response headers announce server-sent
+// events
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// flush sends buffered response data of the ResponseWriter wrapped by a
+// middleware to the client, for use by the wrapper's Flush method
+func flush(w http.ResponseWriter) {
+	http.NewResponseController(w).Flush()
+}
+
+// timeoutResponseWriter enforces the request timeout until the response
+// turns out to be a stream, then switches to an idle timeout that every
+// write pushes back. Either timeout cancels the request with
+// context.DeadlineExceeded as the cause.
+//
+// Like http.TimeoutHandler, it keeps the handler's headers to itself until
+// the response starts, and drops everything the handler writes once the
+// timeout response has been sent.
+type timeoutResponseWriter struct {
+	w           http.ResponseWriter
+	idleTimeout time.Duration
+	cancel      context.CancelCauseFunc
+	streaming   atomic.Bool
+
+	// writeMu guards the response and the fields below
+	writeMu     sync.Mutex
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool // the response was abandoned
+
+	mu    sync.Mutex // guards timer
+	timer *time.Timer
+}
+
+func newTimeoutResponseWriter(w http.ResponseWriter, timeout, idleTimeout time.Duration, cancel context.CancelCauseFunc) *timeoutResponseWriter {
+	tw := &timeoutResponseWriter{w: w, header: w.Header().Clone(), idleTimeout: idleTimeout, cancel: cancel}
+	if timeout > 0 {
+		tw.timer = time.AfterFunc(timeout, tw.expire)
+	}
+	return tw
+}
+
+func (tw *timeoutResponseWriter) expire() {
+	tw.cancel(context.DeadlineExceeded)
+}
+
+// Streaming reports whether the response is being streamed
+func (tw *timeoutResponseWriter) Streaming() bool {
+	return tw.streaming.Load()
+}
+
+// startStreaming replaces the request timeout with the idle timeout
+func (tw *timeoutResponseWriter) startStreaming() {
+	if tw.streaming.Swap(true) {
+		return
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timer != nil {
+		tw.timer.Stop()
+		tw.timer = nil
+	}
+	if tw.idleTimeout > 0 {
+		tw.timer = time.AfterFunc(tw.idleTimeout, tw.expire)
+	}
+}
+
+// touch pushes the idle timeout back after data was sent on a stream
+func (tw *timeoutResponseWriter) touch() {
+	if !tw.Streaming() {
+		return
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timer != nil {
+		tw.timer.Reset(tw.idleTimeout)
+	}
+}
+
+// stop cancels the pending timeout
+func (tw *timeoutResponseWriter) stop() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timer != nil {
+		tw.timer.Stop()
+	}
+}
+
+// abandon ends the response when the handler is given up on, writing the
+// timeout error if timedOut and the handler has not started its response.
+// Whatever the handler writes afterwards is dropped.
+func (tw *timeoutResponseWriter) abandon(r *http.Request, timedOut bool) {
+	tw.writeMu.Lock()
+	defer tw.writeMu.Unlock()
+	tw.timedOut = true
+	if timedOut && !tw.wroteHeader {
+		writeErrorResponse(tw.w, r, http.StatusGatewayTimeout, "gateway_timeout", "Request timed out")
+	}
+}
+
+// Header returns the handler's headers, which are copied to the response
+// when it starts. Trailers set afterwards go to the response directly.
+func (tw *timeoutResponseWriter) Header() http.Header {
+	tw.writeMu.Lock()
+	defer tw.writeMu.Unlock()
+	if tw.wroteHeader && !tw.timedOut {
+		return tw.w.Header()
+	}
+	return tw.header
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(code int) {
+	tw.writeMu.Lock()
+	defer tw.writeMu.Unlock()
+	tw.writeHeader(code)
+}
+
+// writeHeader starts the response; writeMu must be held
+func (tw *timeoutResponseWriter) writeHeader(code int) {
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		header := tw.w.Header()
+		clear(header)
+		for key, values := range tw.header {
+			header[key] = values
+		}
+		if code >= http.StatusOK {
+			tw.wroteHeader = true
+			if isEventStream(header) {
+				tw.startStreaming()
+			}
+		}
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutResponseWriter) Write(b []byte) (int, error) {
+	tw.writeMu.Lock()
+	defer tw.writeMu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	n, err := tw.w.Write(b)
+	tw.touch()
+	return n, err
+}
+
+// Flush marks the response as a stream and sends buffered data
+func (tw *timeoutResponseWriter) Flush() {
+	tw.writeMu.Lock()
+	defer tw.writeMu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	tw.startStreaming()
+	flush(tw.w)
+	tw.touch()
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (tw *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsEventStream(t *testing.T) {
+	header := http.Header{}
+	assert.False(t, isEventStream(header))
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	assert.True(t, isEventStream(header))
+	header.Set("Content-Type", "text/plain")
+	assert.False(t, isEventStream(header))
+}
+
+func TestProxy_ServerSentEvents(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+
+	config := newTestReloadConfig(backend.URL)
+	config.CacheEnabled = true
+	config.RequestIDHeader = "X-Request-ID"
+	config.AccessLogEnabled = true
+	config.StreamIdleTimeout = 5
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(server.URL + "/events")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+		assert.NotEqual(t, "HIT", resp.Header.Get("X-Cache"))
+
+		// The first event arrives while the backend is still responding
+		reader := bufio.NewReader(resp.Body)
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "data: first\n", line)
+
+		release <- struct{}{}
+		rest, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, "\ndata: second\n\n", string(rest))
+		resp.Body.Close()
+	}
+	assert.Equal(t, int32(2), requests.Load(), "streams are never cached")
+}
+
+func TestTimeoutMiddleware_Streaming(t *testing.T) {
+	cause := make(chan error, 1)
+	handler := timeoutMiddleware(30*time.Millisecond, 50*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Regular events keep the stream open past the request timeout
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+		// The idle timeout ends a quiet stream
+		<-r.Context().Done()
+		cause <- context.Cause(r.Context())
+	}))
+
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/events", nil))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "data: 0\n\ndata: 1\n\ndata: 2\n\ndata: 3\n\ndata: 4\n\n", rr.Body.String())
+	assert.True(t, rr.Flushed)
+	assert.True(t, errors.Is(<-cause, context.DeadlineExceeded))
+}
+
+func TestTimeoutMiddleware_FlushStartsStream(t *testing.T) {
+	handler := timeoutMiddleware(20*time.Millisecond, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("chunk"))
+		w.(http.Flusher).Flush()
+		time.Sleep(40 * time.Millisecond)
+		if r.Context().Err() == nil {
+			w.Write([]byte(" done"))
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/download", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "chunk done", rr.Body.String())
+}
+
+func TestTimeoutMiddleware_SlowResponseTimesOut(t *testing.T) {
+	handler := timeoutMiddleware(20*time.Millisecond, time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.Contains(t, rr.Body.String(), "gateway_timeout")
+}
+
+func TestTimeoutMiddleware_SlowBackendRace(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer backend.Close()
+	pool, err := NewBackendPool([]BackendConfig{{URL: backend.URL}}, StrategyRoundRobin, "")
+	assert.NoError(t, err)
+
+	// The pool answers the canceled request with its own error after the
+	// timeout response has been sent
+	finished := make(chan struct{})
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		pool.ServeHTTP(w, r)
+	})
+	handler = timeoutMiddleware(20*time.Millisecond, 0, handler)
+	handler = loggingMiddleware(handler)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/slow", nil))
+	<-finished
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.Equal(t, 1, strings.Count(rr.Body.String(), "gateway_timeout"))
+	assert.Contains(t, helper.GetLogs(), "status=504")
+}
+
+func TestMiddleware_FlushPassesThrough(t *testing.T) {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+		w.(http.Flusher).Flush()
+	})
+	handler = cachingMiddleware(NewCache(10, 60), handler)
+	handler = timeoutMiddleware(time.Second, time.Second, handler)
+	handler = loggingMiddleware(handler)
+	handler = upgradeMiddleware(UpgradeConfig{}, handler)
+	handler = requestIDMiddleware(RequestIDConfig{Header: "X-Request-ID"}, handler)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.True(t, rr.Flushed)
+	assert.NotEmpty(t, rr.Header().Get("X-Request-ID"))
+}
+
+func TestCachingMiddleware_StreamsNotCached(t *testing.T) {
+	cache := NewCache(10, 60)
+	calls := 0
+	handler := cachingMiddleware(cache, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+		}
+		w.Write([]byte("data"))
+		if r.URL.Path == "/flushed" {
+			w.(http.Flusher).Flush()
+		}
+	}))
+
+	for _, path := range []string{"/events", "/flushed"} {
+		calls = 0
+		for i := 0; i < 2; i++ {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+			assert.Equal(t, "data", rr.Body.String())
+			assert.Equal(t, "BYPASS", rr.Header().Get("X-Cache"))
+		}
+		assert.Equal(t, 2, calls, path)
+	}
+}
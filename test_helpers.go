@@ -20,7 +20,10 @@ func SetupTestEnv() *TestHelper {
 	}
 
 	// Capture original environment
-	envVars := []string{"PROXY_PORT", "PROXY_BACKEND", "PROXY_LOG_LEVEL", "PROXY_CONFIG_FILE"}
+	envVars := []string{"PROXY_CONFIG_FILE"}
+	for _, field := range configFields() {
+		envVars = append(envVars, field.envName())
+	}
 	for _, envVar := range envVars {
 		helper.originalEnv[envVar] = os.Getenv(envVar)
 		os.Unsetenv(envVar)
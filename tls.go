@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TLSCertificateConfig names a certificate and its private key, both PEM files
+type TLSCertificateConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// TLSConfig holds the TLS listener settings
+type TLSConfig struct {
+	Certificates   []TLSCertificateConfig // the first is served when no other matches the SNI name
+	MinVersion     uint16
+	CipherSuites   []uint16 // empty uses Go's defaults
+	ReloadInterval time.Duration
+	ClientAuth     string // ClientAuthNone, ClientAuthOptional or ClientAuthRequire
+	ClientCAFile   string // PEM bundle used to verify client certificates
+}
+
+// tlsVersions maps tls_min_version values to protocol versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteID returns the ID of a cipher suite by its Go name, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only suites Go considers secure
+// are accepted.
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// newServerTLSConfig creates the tls.Config for the proxy listener, serving
+// certificates from getCertificate and verifying client certificates if
+// configured
+func newServerTLSConfig(config TLSConfig, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     config.MinVersion,
+		CipherSuites:   config.CipherSuites,
+		GetCertificate: getCertificate,
+		ClientAuth:     clientAuthTypes[config.ClientAuth],
+	}
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		if config.ClientCAFile == "" {
+			return nil, errClientCAMissing
+		}
+		pool, err := loadCertPool(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client CA bundle: %v", err)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// certStore holds the certificates served by the TLS listener and reloads
+// them when their files change
+type certStore struct {
+	files    []TLSCertificateConfig
+	certs    atomic.Pointer[[]*tls.Certificate]
+	versions []fileVersion
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// newCertStore loads every certificate, failing if any cannot be loaded
+func newCertStore(files []TLSCertificateConfig) (*certStore, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no TLS certificate configured")
+	}
+	s := &certStore{files: files, stop: make(chan struct{})}
+	s.versions = s.statFiles()
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads every certificate, keeping the current ones if any fails
+func (s *certStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(s.files))
+	for _, file := range s.files {
+		cert, err := tls.LoadX509KeyPair(file.CertFile, file.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %v", file.CertFile, err)
+		}
+		certs = append(certs, &cert)
+	}
+	s.certs.Store(&certs)
+	return nil
+}
+
+// statFiles returns the current version of every certificate and key file
+func (s *certStore) statFiles() []fileVersion {
+	versions := make([]fileVersion, 0, 2*len(s.files))
+	for _, file := range s.files {
+		versions = append(versions, statFile(file.CertFile), statFile(file.KeyFile))
+	}
+	return versions
+}
+
+// GetCertificate picks the first certificate valid for the client's SNI
+// name, falling back to the first certificate
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.match(hello); cert != nil {
+		return cert, nil
+	}
+	return (*s.certs.Load())[0], nil
+}
+
+// match returns the first certificate valid for the client's SNI name, or
+// nil if none is
+func (s *certStore) match(hello *tls.ClientHelloInfo) *tls.Certificate {
+	if hello.ServerName == "" {
+		return nil
+	}
+	for _, cert := range *s.certs.Load() {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert
+		}
+	}
+	return nil
+}
+
+// Watch polls the certificate files and reloads them whenever one changes.
+// A failed reload is logged and the current certificates stay in use.
+func (s *certStore) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.reloadIfChanged()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// reloadIfChanged reloads the certificates if any file changed since the
+// last check
+func (s *certStore) reloadIfChanged() {
+	versions := s.statFiles()
+	changed := false
+	for i, v := range versions {
+		if v != s.versions[i] {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	s.versions = versions
+	if err := s.load(); err != nil {
+		slog.Error("certificate reload failed", "error", err)
+		return
+	}
+	slog.Info("certificates reloaded", "certificates", len(s.files))
+}
+
+// Stop ends the file watcher
+func (s *certStore) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// httpsRedirectHandler redirects every request to the same URL on the HTTPS
+// listener at httpsPort
+func httpsRedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
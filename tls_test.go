@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCertificate writes a self-signed certificate for dnsNames to dir,
+// using name as its common name and file name
+func writeTestCertificate(t *testing.T, dir, name string, dnsNames ...string) TLSCertificateConfig {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	files := TLSCertificateConfig{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	assert.NoError(t, os.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return files
+}
+
+// servedCommonName returns the common name of the certificate the store
+// serves for serverName
+func servedCommonName(t *testing.T, store *certStore, serverName string) string {
+	t.Helper()
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{
+		ServerName:        serverName,
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+	})
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertStore_SelectsBySNI(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newCertStore([]TLSCertificateConfig{
+		writeTestCertificate(t, dir, "default", "example.com"),
+		writeTestCertificate(t, dir, "api", "api.example.com"),
+		writeTestCertificate(t, dir, "wildcard", "*.internal.example.com"),
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "default", servedCommonName(t, store, "example.com"))
+	assert.Equal(t, "api", servedCommonName(t, store, "api.example.com"))
+	assert.Equal(t, "wildcard", servedCommonName(t, store, "db.internal.example.com"))
+	assert.Equal(t, "default", servedCommonName(t, store, "unknown.test"))
+	assert.Equal(t, "default", servedCommonName(t, store, ""))
+}
+
+func TestCertStore_LoadFailure(t *testing.T) {
+	_, err := newCertStore([]TLSCertificateConfig{{CertFile: "missing.crt", KeyFile: "missing.key"}})
+	assert.ErrorContains(t, err, "failed to load certificate missing.crt")
+
+	_, err = newCertStore(nil)
+	assert.Error(t, err)
+}
+
+func TestCertStore_ReloadsChangedFiles(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	files := writeTestCertificate(t, dir, "old", "example.com")
+	store, err := newCertStore([]TLSCertificateConfig{files})
+	assert.NoError(t, err)
+	defer store.Stop()
+
+	// Unchanged files are not reloaded
+	store.reloadIfChanged()
+	assert.NotContains(t, buf.String(), "certificates reloaded")
+
+	// Replacing the pair swaps the served certificate
+	replacement := writeTestCertificate(t, t.TempDir(), "new", "example.com")
+	later := time.Now().Add(time.Minute)
+	for _, pair := range [][2]string{{replacement.CertFile, files.CertFile}, {replacement.KeyFile, files.KeyFile}} {
+		data, err := os.ReadFile(pair[0])
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(pair[1], data, 0o600))
+		assert.NoError(t, os.Chtimes(pair[1], later, later))
+	}
+	store.reloadIfChanged()
+	assert.Equal(t, "new", servedCommonName(t, store, "example.com"))
+	assert.Contains(t, buf.String(), "certificates reloaded")
+
+	// A broken file keeps the current certificate
+	assert.NoError(t, os.WriteFile(files.KeyFile, []byte("not a key"), 0o600))
+	store.reloadIfChanged()
+	assert.Equal(t, "new", servedCommonName(t, store, "example.com"))
+	assert.Contains(t, buf.String(), "certificate reload failed")
+}
+
+func TestCertStore_Watch(t *testing.T) {
+	dir := t.TempDir()
+	files := writeTestCertificate(t, dir, "old", "example.com")
+	store, err := newCertStore([]TLSCertificateConfig{files})
+	assert.NoError(t, err)
+	defer store.Stop()
+	store.Watch(10 * time.Millisecond)
+
+	replacement := writeTestCertificate(t, t.TempDir(), "replacement", "example.com")
+	later := time.Now().Add(time.Minute)
+	for _, pair := range [][2]string{{replacement.CertFile, files.CertFile}, {replacement.KeyFile, files.KeyFile}} {
+		data, err := os.ReadFile(pair[0])
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(pair[1], data, 0o600))
+		assert.NoError(t, os.Chtimes(pair[1], later, later))
+	}
+
+	assert.Eventually(t, func() bool {
+		return servedCommonName(t, store, "example.com") == "replacement"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestNewServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := writeTestCertificate(t, dir, "proxy", "localhost")
+	store, err := newCertStore([]TLSCertificateConfig{files})
+	assert.NoError(t, err)
+
+	config := defaultConfig()
+	config.TLSMinVersion = "1.3"
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	server.TLS, err = newServerTLSConfig(config.TLS(), store.GetCertificate)
+	assert.NoError(t, err)
+	server.StartTLS()
+	defer server.Close()
+
+	certPEM, err := os.ReadFile(files.CertFile)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+
+	newClient := func(maxVersion uint16) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: "localhost",
+			MaxVersion: maxVersion,
+		}}}
+	}
+
+	resp, err := newClient(tls.VersionTLS13).Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, uint16(tls.VersionTLS13), resp.TLS.Version)
+
+	_, err = newClient(tls.VersionTLS12).Get(server.URL)
+	assert.Error(t, err, "TLS 1.2 clients are refused when the minimum is 1.3")
+}
+
+func TestConfig_TLS(t *testing.T) {
+	config := defaultConfig()
+	config.TLSCertFile = "default.crt"
+	config.TLSKeyFile = "default.key"
+	config.TLSCertificates = []TLSCertificateConfig{{CertFile: "api.crt", KeyFile: "api.key"}}
+	config.TLSCipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_BOGUS"}
+
+	tlsConfig := config.TLS()
+	assert.Equal(t, []TLSCertificateConfig{
+		{CertFile: "default.crt", KeyFile: "default.key"},
+		{CertFile: "api.crt", KeyFile: "api.key"},
+	}, tlsConfig.Certificates)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+	assert.Equal(t, 30*time.Second, tlsConfig.ReloadInterval)
+}
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     int
+		target   string
+		expected string
+	}{
+		{"default port", 443, "http://example.com/path?q=1", "https://example.com/path?q=1"},
+		{"custom port", 8443, "http://example.com:8080/path", "https://example.com:8443/path"},
+		{"IPv6 host", 8443, "http://[::1]:8080/", "https://[::1]:8443/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			httpsRedirectHandler(tt.port).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, tt.target, nil))
+
+			assert.Equal(t, http.StatusPermanentRedirect, rr.Code)
+			assert.Equal(t, tt.expected, rr.Header().Get("Location"))
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// W3C trace context headers
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// maxTraceStateLength is the longest tracestate value propagated, per the
+// W3C trace context recommendation
+const maxTraceStateLength = 512
+
+// TracingConfig holds the tracing settings
+type TracingConfig struct {
+	Endpoint    string  // OTLP/HTTP traces endpoint, such as http://localhost:4318/v1/traces
+	ServiceName string  // service.name reported with every span
+	SampleRatio float64 // fraction of new traces recorded, from 0 to 1
+}
+
+// SpanKind describes a span's role, using the OTLP enum values
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span that is propagated across processes
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// parseTraceparent parses a W3C traceparent header. Versions above 00 are
+// accepted as long as they start with the version 00 fields.
+func parseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	if len(header) < 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return sc, false
+	}
+	version := header[:2]
+	if version == "ff" || (version == "00" && len(header) != 55) || (len(header) > 55 && header[55] != '-') {
+		return sc, false
+	}
+	if !isLowerHex(version) || !isLowerHex(header[3:35]) || !isLowerHex(header[36:52]) || !isLowerHex(header[53:55]) {
+		return sc, false
+	}
+
+	hex.Decode(sc.TraceID[:], []byte(header[3:35]))
+	hex.Decode(sc.SpanID[:], []byte(header[36:52]))
+	flags, _ := hex.DecodeString(header[53:55])
+	if sc.TraceID == (TraceID{}) || sc.SpanID == (SpanID{}) {
+		return sc, false
+	}
+
+	sc.Sampled = flags[0]&0x01 != 0
+	return sc, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// traceparent formats the span context as a version 00 traceparent header
+func (sc SpanContext) traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// spanAttribute is a key-value pair recorded on a span
+type spanAttribute struct {
+	Key   string
+	Value interface{} // string, bool, int, int64 or float64
+}
+
+// Span is a timed operation within a trace. Its methods are safe to call on
+// a nil receiver, so code can record details whether or not tracing is on.
+type Span struct {
+	tracer   *Tracer
+	context  SpanContext
+	parentID SpanID
+	kind     SpanKind
+	start    time.Time
+
+	mu         sync.Mutex
+	name       string
+	end        time.Time
+	attributes []spanAttribute
+	failed     bool
+	message    string
+	ended      bool
+}
+
+// Context returns the span's propagated context
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetName replaces the span's name, such as once the route is known
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttribute records a key-value pair on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, spanAttribute{Key: key, Value: value})
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.message = message
+}
+
+// End finishes the span and hands it to the exporter if it is sampled.
+// Later calls have no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = s.tracer.now()
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.exporter.export(s)
+	}
+}
+
+// spanKey is the context key for the current span
+type spanKey struct{}
+
+// spanFromContext returns the current span of the context, or nil
+func spanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// contextWithSpan returns a copy of ctx with span as its current span
+func contextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// startSpan starts a child of the context's current span. Without a current
+// span tracing is off for the request, and it returns ctx and a nil span.
+func startSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.tracer.newSpan(name, kind, parent.context, true)
+	return contextWithSpan(ctx, span), span
+}
+
+// injectTraceContext sets the traceparent header for a request made as part
+// of span. Without a span, headers pass through unchanged.
+func injectTraceContext(header http.Header, span *Span) {
+	if span == nil {
+		return
+	}
+	header.Set(traceparentHeader, span.context.traceparent())
+	if span.context.TraceState != "" {
+		header.Set(tracestateHeader, span.context.TraceState)
+	} else {
+		header.Del(tracestateHeader)
+	}
+}
+
+// spanExporter receives finished, sampled spans
+type spanExporter interface {
+	export(span *Span)
+	shutdown()
+}
+
+// Tracer starts spans and exports the sampled ones
+type Tracer struct {
+	config   TracingConfig
+	exporter spanExporter
+	now      func() time.Time
+}
+
+// NewTracer creates a tracer exporting to the configured OTLP/HTTP endpoint
+func NewTracer(config TracingConfig) *Tracer {
+	return newTracer(config, newOTLPExporter(config.Endpoint, config.ServiceName))
+}
+
+func newTracer(config TracingConfig, exporter spanExporter) *Tracer {
+	return &Tracer{config: config, exporter: exporter, now: time.Now}
+}
+
+// Close flushes spans not yet exported. It is safe to call on nil.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	t.exporter.shutdown()
+}
+
+// newSpan creates a span. With a parent the span joins its trace and follows
+// its sampling decision; otherwise it starts a new trace sampled at the
+// configured ratio.
+func (t *Tracer) newSpan(name string, kind SpanKind, parent SpanContext, hasParent bool) *Span {
+	span := &Span{tracer: t, name: name, kind: kind, start: t.now()}
+	binary.BigEndian.PutUint64(span.context.SpanID[:], nonZeroRandom())
+
+	if hasParent {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.context.TraceState = parent.TraceState
+		span.parentID = parent.SpanID
+		return span
+	}
+
+	binary.BigEndian.PutUint64(span.context.TraceID[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(span.context.TraceID[8:], nonZeroRandom())
+	span.context.Sampled = t.shouldSample(span.context.TraceID)
+	return span
+}
+
+// shouldSample decides from the trace ID alone, so every process using the
+// same ratio makes the same decision for a trace
+func (t *Tracer) shouldSample(id TraceID) bool {
+	ratio := t.config.SampleRatio
+	switch {
+	case ratio >= 1:
+		return true
+	case ratio <= 0:
+		return false
+	}
+	bound := uint64(ratio * (1 << 63))
+	return binary.BigEndian.Uint64(id[8:])>>1 < bound
+}
+
+func nonZeroRandom() uint64 {
+	for {
+		if n := rand.Uint64(); n != 0 {
+			return n
+		}
+	}
+}
+
+// tracingMiddleware starts a server span for every request, continuing the
+// trace of an incoming traceparent header
+func tracingMiddleware(tracer *Tracer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, hasParent := parseTraceparent(r.Header.Get(traceparentHeader))
+		if hasParent {
+			if state := r.Header.Get(tracestateHeader); len(state) <= maxTraceStateLength {
+				parent.TraceState = state
+			}
+		}
+
+		span := tracer.newSpan("HTTP "+r.Method, SpanKindServer, parent, hasParent)
+		r, info := withRequestInfo(r.WithContext(contextWithSpan(r.Context(), span)))
+
+		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(lrw, r)
+
+		snapshot := info.snapshot()
+		if snapshot.Route != "" {
+			span.SetName(r.Method + " " + snapshot.Route)
+			span.SetAttribute("http.route", snapshot.Route)
+		}
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", getClientKey(r))
+		span.SetAttribute("http.response.status_code", lrw.statusCode)
+		span.SetAttribute("proxy.request_id", snapshot.ID)
+		if lrw.statusCode >= http.StatusInternalServerError {
+			span.SetError(fmt.Sprintf("status %d", lrw.statusCode))
+		}
+		span.End()
+	})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingExporter keeps exported spans in memory
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *recordingExporter) export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func (e *recordingExporter) shutdown() {}
+
+// byName returns the exported spans by name
+func (e *recordingExporter) byName() map[string]*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make(map[string]*Span)
+	for _, span := range e.spans {
+		spans[span.name] = span
+	}
+	return spans
+}
+
+func (s *Span) attribute(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, attr := range s.attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.traceparent())
+
+	sc, ok = parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.True(t, ok)
+	assert.False(t, sc.Sampled)
+
+	_, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	assert.True(t, ok, "later versions may append fields")
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		_, ok := parseTraceparent(invalid)
+		assert.False(t, ok, invalid)
+	}
+}
+
+func TestTracer_SampleRatio(t *testing.T) {
+	never := newTracer(TracingConfig{SampleRatio: 0}, &recordingExporter{})
+	always := newTracer(TracingConfig{SampleRatio: 1}, &recordingExporter{})
+	half := newTracer(TracingConfig{SampleRatio: 0.5}, &recordingExporter{})
+
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		assert.False(t, never.newSpan("op", SpanKindServer, SpanContext{}, false).Context().Sampled)
+		assert.True(t, always.newSpan("op", SpanKindServer, SpanContext{}, false).Context().Sampled)
+		if half.newSpan("op", SpanKindServer, SpanContext{}, false).Context().Sampled {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 500, sampled, 100)
+}
+
+func TestTracer_FollowsParentSampling(t *testing.T) {
+	tracer := newTracer(TracingConfig{SampleRatio: 0}, &recordingExporter{})
+	parent, _ := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	span := tracer.newSpan("op", SpanKindServer, parent, true)
+	assert.True(t, span.Context().Sampled)
+	assert.Equal(t, parent.TraceID, span.Context().TraceID)
+	assert.Equal(t, parent.SpanID, span.parentID)
+	assert.NotEqual(t, parent.SpanID, span.Context().SpanID)
+}
+
+func TestStartSpan_WithoutParentIsNil(t *testing.T) {
+	ctx, span := startSpan(context.Background(), "op", SpanKindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, context.Background(), ctx)
+
+	// A nil span is safe to use
+	span.SetAttribute("key", "value")
+	span.SetError("failed")
+	span.End()
+
+	header := http.Header{}
+	header.Set("traceparent", "unchanged")
+	injectTraceContext(header, span)
+	assert.Equal(t, "unchanged", header.Get("traceparent"))
+}
+
+func TestSpan_EndExportsOnce(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := newTracer(TracingConfig{SampleRatio: 1}, exporter)
+
+	span := tracer.newSpan("op", SpanKindInternal, SpanContext{}, false)
+	span.End()
+	span.End()
+	assert.Len(t, exporter.spans, 1)
+
+	unsampled := newTracer(TracingConfig{SampleRatio: 0}, exporter)
+	unsampled.newSpan("op", SpanKindInternal, SpanContext{}, false).End()
+	assert.Len(t, exporter.spans, 1, "unsampled spans are not exported")
+}
+
+func TestTracingMiddleware_SpansAndPropagation(t *testing.T) {
+	helper := SetupTestEnv()
+	defer helper.RestoreEnv()
+
+	var upstreamTraceparent, upstreamTracestate string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		upstreamTracestate = r.Header.Get("tracestate")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	config := newTestReloadConfig(backend.URL)
+	config.CacheEnabled = true
+	config.RateLimitEnabled = true
+	config.TracingEnabled = true
+	config.TracingSampleRatio = 1
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+
+	// Replace the OTLP exporter with an in-memory one
+	exporter := &recordingExporter{}
+	proxy.tracer.exporter.shutdown()
+	proxy.tracer.exporter = exporter
+
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=value")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	spans := exporter.byName()
+	server := spans["GET default"]
+	cache := spans["cache lookup"]
+	rateLimit := spans["rate limit check"]
+	upstream := spans["upstream GET"]
+	if !assert.NotNil(t, server) || !assert.NotNil(t, cache) || !assert.NotNil(t, rateLimit) || !assert.NotNil(t, upstream) {
+		return
+	}
+
+	incoming, _ := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Equal(t, incoming.TraceID, server.Context().TraceID)
+	assert.Equal(t, incoming.SpanID, server.parentID)
+	assert.Equal(t, server.Context().SpanID, cache.parentID)
+	assert.Equal(t, server.Context().SpanID, rateLimit.parentID)
+	assert.Equal(t, server.Context().SpanID, upstream.parentID)
+
+	assert.Equal(t, 200, server.attribute("http.response.status_code"))
+	assert.Equal(t, "default", server.attribute("http.route"))
+	assert.Equal(t, false, cache.attribute("cache.hit"))
+	assert.Equal(t, true, rateLimit.attribute("rate_limit.allowed"))
+	assert.Equal(t, 200, upstream.attribute("http.response.status_code"))
+
+	assert.Equal(t, upstream.Context().traceparent(), upstreamTraceparent, "the backend continues the upstream span")
+	assert.Equal(t, "vendor=value", upstreamTracestate)
+	assert.Contains(t, helper.GetLogs(), "trace_id=4bf92f3577b34da6a3ce929d0e0e4736")
+}
+
+func TestTracingMiddleware_MarksServerErrors(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := newTracer(TracingConfig{SampleRatio: 1}, exporter)
+	handler := tracingMiddleware(tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	span := exporter.byName()["HTTP POST"]
+	if assert.NotNil(t, span) {
+		assert.True(t, span.failed)
+		assert.Equal(t, "status 502", span.message)
+		assert.Len(t, span.attribute("proxy.request_id"), 32)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UpgradeConfig holds the limits for upgraded connections such as WebSockets
+type UpgradeConfig struct {
+	IdleTimeout time.Duration // closes the connection after no data in either direction; 0 disables
+	MaxDuration time.Duration // closes the connection this long after the upgrade; 0 disables
+}
+
+// isUpgradeRequest reports whether the client asks to switch protocols,
+// such as to a WebSocket
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hijack takes over the connection of the ResponseWriter wrapped by a
+// middleware, for use by the wrapper's Hijack method
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w).Hijack()
+}
+
+// upgradeMiddleware applies the idle and maximum duration limits to
+// upgraded connections and logs their lifetime and traffic when they close
+func upgradeMiddleware(config UpgradeConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r, _ = withRequestInfo(r)
+		next.ServeHTTP(&upgradeResponseWriter{ResponseWriter: w, config: config, request: r}, r)
+	})
+}
+
+// upgradeResponseWriter wraps the connection when a handler hijacks it
+type upgradeResponseWriter struct {
+	http.ResponseWriter
+	config  UpgradeConfig
+	request *http.Request
+}
+
+func (w *upgradeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := hijack(w.ResponseWriter)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newUpgradedConn(conn, w.config, w.request), rw, nil
+}
+
+// Flush sends buffered data to the client
+func (w *upgradeResponseWriter) Flush() {
+	flush(w.ResponseWriter)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController
+func (w *upgradeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Reasons an upgraded connection closed
+const (
+	upgradeClosed      = "closed"
+	upgradeIdleTimeout = "idle_timeout"
+	upgradeMaxDuration = "max_duration"
+)
+
+// upgradedConn is a hijacked client connection. Every read or write pushes
+// its deadline back by the idle timeout, never past the maximum duration.
+type upgradedConn struct {
+	net.Conn
+	config   UpgradeConfig
+	request  *http.Request
+	start    time.Time
+	bytesIn  atomic.Int64 // read from the client
+	bytesOut atomic.Int64 // written to the client
+	reason   atomic.Value
+	once     sync.Once
+}
+
+func newUpgradedConn(conn net.Conn, config UpgradeConfig, r *http.Request) *upgradedConn {
+	c := &upgradedConn{Conn: conn, config: config, request: r, start: time.Now()}
+	c.extendDeadline()
+	return c
+}
+
+// extendDeadline sets the deadline for the next read or write
+func (c *upgradedConn) extendDeadline() {
+	var deadline time.Time
+	if c.config.IdleTimeout > 0 {
+		deadline = time.Now().Add(c.config.IdleTimeout)
+	}
+	if c.config.MaxDuration > 0 {
+		end := c.start.Add(c.config.MaxDuration)
+		if deadline.IsZero() || end.Before(deadline) {
+			deadline = end
+		}
+	}
+	if !deadline.IsZero() {
+		c.Conn.SetDeadline(deadline)
+	}
+}
+
+// observe records traffic and the reason a read or write failed
+func (c *upgradedConn) observe(n int, err error) {
+	if n > 0 {
+		c.extendDeadline()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		reason := upgradeIdleTimeout
+		if c.config.MaxDuration > 0 && time.Since(c.start) >= c.config.MaxDuration {
+			reason = upgradeMaxDuration
+		}
+		c.reason.CompareAndSwap(nil, reason)
+	}
+}
+
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn.Add(int64(n))
+	c.observe(n, err)
+	return n, err
+}
+
+func (c *upgradedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut.Add(int64(n))
+	c.observe(n, err)
+	return n, err
+}
+
+// Close closes the connection and logs its lifetime and traffic
+func (c *upgradedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		reason, _ := c.reason.Load().(string)
+		if reason == "" {
+			reason = upgradeClosed
+		}
+		attrs := append(requestAttrs(c.request),
+			"protocol", c.request.Header.Get("Upgrade"),
+			"duration", time.Since(c.start),
+			"bytes_in", c.bytesIn.Load(),
+			"bytes_out", c.bytesOut.Load(),
+			"reason", reason)
+		slog.Info("upgraded connection closed", attrs...)
+	})
+	return err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for logging from other goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newEchoUpgradeServer returns a backend that switches to a protocol
+// echoing every line back
+func newEchoUpgradeServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) {
+			w.Write([]byte("plain"))
+			return
+		}
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			rw.WriteString(line)
+			rw.Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialUpgrade opens a connection to server and switches it to the echo protocol
+func dialUpgrade(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(t, err)
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	assert.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	assert.NoError(t, err)
+	return conn, reader, resp
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	assert.False(t, isUpgradeRequest(req))
+
+	req.Header.Set("Upgrade", "websocket")
+	assert.False(t, isUpgradeRequest(req), "Upgrade needs Connection: upgrade")
+
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	assert.True(t, isUpgradeRequest(req))
+}
+
+func TestProxy_Upgrade(t *testing.T) {
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	backend := newEchoUpgradeServer(t)
+	config := newTestReloadConfig(backend.URL)
+	config.CacheEnabled = true
+	config.RequestIDHeader = "X-Request-ID"
+	proxy, err := NewProxy(config, nil)
+	assert.NoError(t, err)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, reader, resp := dialUpgrade(t, server)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode, "upgrades are never served from the cache")
+		assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+
+		_, err = io.WriteString(conn, "hello\n")
+		assert.NoError(t, err)
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "hello\n", line)
+		conn.Close()
+	}
+
+	assert.Eventually(t, func() bool {
+		return bytes.Count([]byte(logs.String()), []byte("upgraded connection closed")) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Contains(t, logs.String(), "protocol=echo")
+	assert.Contains(t, logs.String(), "bytes_in=6")
+	assert.Contains(t, logs.String(), "reason=closed")
+	assert.Contains(t, logs.String(), "status=101")
+}
+
+func TestTimeoutMiddleware_ExemptsUpgrades(t *testing.T) {
+	handler := timeoutMiddleware(10*time.Millisecond, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		if r.Context().Err() != nil {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSwitchingProtocols, rr.Code)
+}
+
+func TestUpgradedConn_Limits(t *testing.T) {
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name   string
+		config UpgradeConfig
+		reason string
+	}{
+		{"idle timeout", UpgradeConfig{IdleTimeout: 20 * time.Millisecond}, "reason=idle_timeout"},
+		{"max duration", UpgradeConfig{IdleTimeout: time.Minute, MaxDuration: 50 * time.Millisecond}, "reason=max_duration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, peer := net.Pipe()
+			defer peer.Close()
+			conn := newUpgradedConn(client, tt.config, httptest.NewRequest("GET", "/ws", nil))
+
+			// Traffic keeps the connection open past the idle timeout
+			go func() {
+				for i := 0; i < 3; i++ {
+					peer.Write([]byte("x"))
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+			buf := make([]byte, 1)
+			for i := 0; i < 3; i++ {
+				_, err := conn.Read(buf)
+				assert.NoError(t, err)
+			}
+
+			start := time.Now()
+			_, err := conn.Read(buf)
+			assert.Error(t, err)
+			assert.Less(t, time.Since(start), time.Second)
+			conn.Close()
+			assert.Contains(t, logs.String(), tt.reason)
+			assert.Contains(t, logs.String(), "bytes_in=3")
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ValidationError describes an invalid configuration value
+type ValidationError struct {
+	Field   string // path of the value, such as "routes[0].backends[1].url"
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors lists every problem found in a configuration
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d configuration error(s):", len(errs))
+	for _, err := range errs {
+		b.WriteString("\n  ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// validator collects validation errors
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) addf(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) between(field string, value, min, max int) {
+	if value < min || value > max {
+		v.addf(field, "must be between %d and %d, got %d", min, max, value)
+	}
+}
+
+func (v *validator) atLeast(field string, value, min int) {
+	if value < min {
+		v.addf(field, "must be at least %d, got %d", min, value)
+	}
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.addf(field, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+func (v *validator) path(field, path string) {
+	if !strings.HasPrefix(path, "/") {
+		v.addf(field, "must start with \"/\", got %q", path)
+	}
+}
+
+func (v *validator) backendURL(field, raw string) {
+	if raw == "" {
+		v.addf(field, "is required")
+		return
+	}
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		v.addf(field, "is not a valid URL: %v", err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		v.addf(field, "must be an http or https URL, got %q", raw)
+	case u.Host == "":
+		v.addf(field, "must include a host, got %q", raw)
+	}
+}
+
+func (v *validator) backends(field string, backends []BackendConfig) {
+	if len(backends) == 0 {
+		v.addf(field, "at least one backend is required")
+	}
+	for i, backend := range backends {
+		v.backendURL(fmt.Sprintf("%s[%d].url", field, i), backend.URL)
+		v.atLeast(fmt.Sprintf("%s[%d].weight", field, i), backend.Weight, 0)
+		if backend.TLS != nil {
+			v.backendTLS(fmt.Sprintf("%s[%d].tls.", field, i), *backend.TLS)
+		}
+		v.backendProtocol(fmt.Sprintf("%s[%d]", field, i), backend)
+	}
+}
+
+func (v *validator) loadBalancer(prefix, strategy, hashKey string) {
+	v.oneOf(prefix+"load_balancer", strategy, StrategyRoundRobin, StrategyWeightedRoundRobin,
+		StrategyLeastConnections, StrategyRandomTwoChoices, StrategyConsistentHash)
+	if _, err := parseHashKey(hashKey); err != nil {
+		v.addf(prefix+"hash_key", "must be client_ip, header:<name> or cookie:<name>, got %q", hashKey)
+	}
+}
+
+// methodPattern matches HTTP method tokens
+var methodPattern = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// headerNamePattern matches HTTP header field names
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// generatedRouteNamePattern matches the names given to unnamed routes
+var generatedRouteNamePattern = regexp.MustCompile(`^route-[0-9]+$`)
+
+func (v *validator) route(field string, route RouteConfig, names map[string]string) {
+	if route.Name != "" {
+		switch {
+		case route.Name == "shared":
+			v.addf(field+".name", "%q is reserved for the shared cache and rate limiter", route.Name)
+		case generatedRouteNamePattern.MatchString(route.Name):
+			v.addf(field+".name", "%q is reserved for unnamed routes", route.Name)
+		}
+		if other, ok := names[route.Name]; ok {
+			v.addf(field+".name", "duplicates the name of %s", other)
+		}
+		names[route.Name] = field
+	}
+
+	if route.Host != "" {
+		host := strings.TrimPrefix(route.Host, "*.")
+		switch {
+		case strings.Contains(host, "*"):
+			v.addf(field+".host", "wildcards are only allowed as a leading \"*.\", got %q", route.Host)
+		case strings.ContainsAny(host, ":/"):
+			v.addf(field+".host", "must be a host name without port or path, got %q", route.Host)
+		}
+	}
+	if route.Path != "" {
+		v.path(field+".path", route.Path)
+	}
+	if route.PathPrefix != "" {
+		v.path(field+".path_prefix", route.PathPrefix)
+	}
+	if route.PathRegex != "" {
+		if _, err := regexp.Compile(route.PathRegex); err != nil {
+			v.addf(field+".path_regex", "is not a valid regular expression: %v", err)
+		}
+	}
+	for i, method := range route.Methods {
+		if !methodPattern.MatchString(method) {
+			v.addf(fmt.Sprintf("%s.methods[%d]", field, i), "is not a valid HTTP method: %q", method)
+		}
+	}
+	for name := range route.Headers {
+		if name == "" {
+			v.addf(field+".headers", "header names must not be empty")
+		}
+	}
+	if route.GRPCService != "" || route.GRPCMethod != "" {
+		v.grpcRoute(field, route)
+	}
+
+	v.backends(field+".backends", route.Backends)
+	if route.LoadBalancer != "" || route.HashKey != "" {
+		v.loadBalancer(field+".", orDefault(route.LoadBalancer, StrategyRoundRobin), route.HashKey)
+	}
+
+	if c := route.Cache; c != nil {
+		v.atLeast(field+".cache.size", c.Size, 0)
+		v.atLeast(field+".cache.ttl_seconds", c.TTLSeconds, 0)
+	}
+	if rl := route.RateLimit; rl != nil {
+		v.atLeast(field+".rate_limit.requests_per_minute", rl.RequestsPerMinute, 0)
+		v.atLeast(field+".rate_limit.burst_size", rl.BurstSize, 0)
+	}
+	if t := route.Timeout; t != nil {
+		v.atLeast(field+".timeout.seconds", t.Seconds, 0)
+		v.atLeast(field+".timeout.stream_idle_seconds", t.StreamIdleSeconds, 0)
+	}
+}
+
+// tls checks the TLS listener settings, loading each certificate so a
+// mismatched or unreadable pair is caught before the server starts
+func (v *validator) tls(c *Config) {
+	v.oneOf("tls_min_version", c.TLSMinVersion, "1.0", "1.1", "1.2", "1.3")
+	for i, name := range c.TLSCipherSuites {
+		if _, ok := cipherSuiteID(name); !ok {
+			v.addf(fmt.Sprintf("tls_cipher_suites[%d]", i), "is not a supported cipher suite: %q", name)
+		}
+	}
+	v.atLeast("tls_reload_interval_seconds", c.TLSReloadInterval, 0)
+	if c.TLSClientAuth != ClientAuthNone {
+		if c.TLSClientCAFile == "" {
+			v.addf("tls_client_ca_file", "is required when tls_client_auth is %q", c.TLSClientAuth)
+		} else if _, err := loadCertPool(c.TLSClientCAFile); err != nil {
+			v.addf("tls_client_ca_file", "failed to load CA bundle: %v", err)
+		}
+	}
+
+	if c.TLSCertFile == "" && c.TLSKeyFile == "" && len(c.TLSCertificates) == 0 {
+		if !c.ACMEEnabled {
+			v.addf("tls_cert_file", "is required when tls_enabled is set without acme_enabled")
+		}
+		return
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		v.certificate("tls_cert_file", "tls_key_file", c.TLSCertFile, c.TLSKeyFile)
+	}
+	for i, cert := range c.TLSCertificates {
+		field := fmt.Sprintf("tls_certificates[%d]", i)
+		v.certificate(field+".cert_file", field+".key_file", cert.CertFile, cert.KeyFile)
+	}
+}
+
+// acme checks the automatic certificate management settings
+func (v *validator) acme(c *Config) {
+	if !c.TLSEnabled {
+		v.addf("acme_enabled", "requires tls_enabled")
+	}
+	v.backendURL("acme_directory_url", c.ACMEDirectoryURL)
+	if len(c.ACMEDomains) == 0 {
+		v.addf("acme_domains", "at least one domain is required")
+	}
+	for i, domain := range c.ACMEDomains {
+		if domain == "" || strings.ContainsAny(domain, "*:/ ") {
+			v.addf(fmt.Sprintf("acme_domains[%d]", i), "must be a host name without wildcard, port or path, got %q", domain)
+		}
+	}
+	if c.ACMECacheDir == "" {
+		v.addf("acme_cache_dir", "is required")
+	}
+	if c.ACMECAFile != "" {
+		if _, err := loadCertPool(c.ACMECAFile); err != nil {
+			v.addf("acme_ca_file", "failed to load CA bundle: %v", err)
+		}
+	}
+}
+
+// backendTLS checks the settings for dialing HTTPS backends, loading the
+// files they name
+func (v *validator) backendTLS(prefix string, c BackendTLSConfig) {
+	if c.CAFile != "" {
+		if _, err := loadCertPool(c.CAFile); err != nil {
+			v.addf(prefix+"ca_file", "failed to load CA bundle: %v", err)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		v.certificate(prefix+"cert_file", prefix+"key_file", c.CertFile, c.KeyFile)
+	}
+}
+
+// grpcNamePattern matches a fully-qualified protobuf service name
+var grpcNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// grpcMethodPattern matches a protobuf method name
+var grpcMethodPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (v *validator) grpcRoute(field string, route RouteConfig) {
+	switch {
+	case route.GRPCService == "":
+		v.addf(field+".grpc_method", "requires grpc_service")
+	case !grpcNamePattern.MatchString(route.GRPCService):
+		v.addf(field+".grpc_service", "must be a fully-qualified service name such as \"package.Service\", got %q", route.GRPCService)
+	}
+	if route.GRPCMethod != "" && !grpcMethodPattern.MatchString(route.GRPCMethod) {
+		v.addf(field+".grpc_method", "must be a method name such as \"GetUser\", got %q", route.GRPCMethod)
+	}
+	if route.Path != "" || route.PathPrefix != "" || route.PathRegex != "" {
+		v.addf(field+".grpc_service", "cannot be combined with path, path_prefix or path_regex")
+	}
+	v.grpcBackends(field+".backends", route.Backends, "gRPC routes")
+}
+
+// grpcBackends checks that backends are reached over HTTP/2, which gRPC
+// requires
+func (v *validator) grpcBackends(field string, backends []BackendConfig, feature string) {
+	for i, backend := range backends {
+		u, err := url.Parse(backend.URL)
+		if err != nil {
+			continue
+		}
+		protocol := orDefault(backend.Protocol, BackendProtocolAuto)
+		if protocol == BackendProtocolHTTP1 || (u.Scheme == "http" && protocol != BackendProtocolH2C) {
+			v.addf(fmt.Sprintf("%s[%d].protocol", field, i), "%s require HTTP/2; use h2c for http backends", feature)
+		}
+	}
+}
+
+func (v *validator) backendProtocol(field string, backend BackendConfig) {
+	protocol := orDefault(backend.Protocol, BackendProtocolAuto)
+	v.oneOf(field+".protocol", protocol, BackendProtocolAuto, BackendProtocolHTTP1, BackendProtocolHTTP2, BackendProtocolH2C)
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		return
+	}
+	switch {
+	case protocol == BackendProtocolHTTP2 && u.Scheme != "https":
+		v.addf(field+".protocol", "http2 requires an https URL; use h2c for cleartext backends")
+	case protocol == BackendProtocolH2C && u.Scheme != "http":
+		v.addf(field+".protocol", "h2c requires an http URL; use http2 for TLS backends")
+	}
+}
+
+func (v *validator) certificate(certField, keyField, certFile, keyFile string) {
+	switch {
+	case certFile == "":
+		v.addf(certField, "is required")
+	case keyFile == "":
+		v.addf(keyField, "is required")
+	default:
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			v.addf(certField, "failed to load certificate: %v", err)
+		}
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// Validate checks every configuration value, returning ValidationErrors
+// listing all problems found, or nil if the configuration is valid
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.between("port", c.Port, 1, 65535)
+	v.oneOf("log_level", c.LogLevel, "debug", "info", "warn", "error")
+	v.oneOf("log_format", c.LogFormat, LogFormatText, LogFormatJSON)
+
+	// Backends and routes
+	switch {
+	case len(c.Routes) > 0:
+		if len(c.Backends) > 0 {
+			v.backends("backends", c.Backends)
+		}
+	case len(c.Backends) > 0:
+		v.backends("backends", c.Backends)
+	default:
+		v.backendURL("backend", c.Backend)
+	}
+	v.loadBalancer("", orDefault(c.LoadBalancer, StrategyRoundRobin), c.HashKey)
+	names := make(map[string]string)
+	for i, route := range c.Routes {
+		v.route(fmt.Sprintf("routes[%d]", i), route, names)
+	}
+
+	// Middleware
+	v.atLeast("cache_size", c.CacheSize, 1)
+	v.atLeast("cache_ttl_seconds", c.CacheTTL, 1)
+	v.atLeast("request_timeout_seconds", c.RequestTimeout, 0)
+	v.atLeast("shutdown_timeout_seconds", c.ShutdownTimeout, 0)
+	v.atLeast("rate_limit_requests_per_minute", c.RateLimitRPM, 1)
+	v.atLeast("rate_limit_burst_size", c.RateLimitBurst, 1)
+	v.path("metrics_path", c.MetricsPath)
+	v.between("admin_port", c.AdminPort, 1, 65535)
+	if c.AdminEnabled && c.AdminPort == c.Port {
+		v.addf("admin_port", "must differ from port %d", c.Port)
+	}
+	v.atLeast("config_reload_interval_seconds", c.ConfigReloadInterval, 0)
+	v.atLeast("upgrade_idle_timeout_seconds", c.UpgradeIdleTimeout, 0)
+	v.atLeast("upgrade_max_duration_seconds", c.UpgradeMaxDuration, 0)
+	v.atLeast("stream_idle_timeout_seconds", c.StreamIdleTimeout, 0)
+
+	// TLS
+	v.oneOf("tls_client_auth", c.TLSClientAuth, ClientAuthNone, ClientAuthOptional, ClientAuthRequire)
+	if c.TLSEnabled {
+		v.tls(c)
+	} else if c.TLSClientAuth != ClientAuthNone {
+		v.addf("tls_client_auth", "requires tls_enabled")
+	}
+	for i, route := range c.Routes {
+		if route.ClientCertRequired && c.TLSClientAuth == ClientAuthNone {
+			v.addf(fmt.Sprintf("routes[%d].client_cert_required", i), "requires tls_client_auth to be %q or %q",
+				ClientAuthOptional, ClientAuthRequire)
+		}
+	}
+	v.backendTLS("backend_tls_", c.BackendTLS())
+	if c.ACMEEnabled {
+		v.acme(c)
+	}
+	if c.HTTPRedirectEnabled || (c.ACMEEnabled && c.ACMEHTTPChallenge) {
+		v.between("http_redirect_port", c.HTTPRedirectPort, 1, 65535)
+		switch {
+		case !c.TLSEnabled && c.HTTPRedirectEnabled:
+			v.addf("http_redirect_enabled", "requires tls_enabled")
+		case c.HTTPRedirectPort == c.Port:
+			v.addf("http_redirect_port", "must differ from port %d", c.Port)
+		case c.AdminEnabled && c.HTTPRedirectPort == c.AdminPort:
+			v.addf("http_redirect_port", "must differ from admin_port %d", c.AdminPort)
+		}
+	}
+
+	// HTTP/2
+	if c.H2CEnabled && c.TLSEnabled {
+		v.addf("h2c_enabled", "requires tls_enabled to be false; TLS clients negotiate HTTP/2 with http2_enabled")
+	}
+	v.atLeast("http2_max_concurrent_streams", c.HTTP2MaxConcurrentStreams, 1)
+	v.between("http2_max_connection_window_bytes", c.HTTP2MaxConnectionWindow, http2MinWindow, http2MaxWindow)
+	v.between("http2_max_stream_window_bytes", c.HTTP2MaxStreamWindow, http2MinWindow, http2MaxWindow)
+
+	// Request IDs
+	if c.RequestIDHeader != "" && !headerNamePattern.MatchString(c.RequestIDHeader) {
+		v.addf("request_id_header", "is not a valid header name: %q", c.RequestIDHeader)
+	}
+	for i, network := range c.RequestIDTrustedNetworks {
+		if _, err := netip.ParsePrefix(network); err != nil {
+			v.addf(fmt.Sprintf("request_id_trusted_networks[%d]", i), "must be a CIDR such as 10.0.0.0/8, got %q", network)
+		}
+	}
+
+	// Tracing
+	if c.TracingEnabled {
+		v.backendURL("tracing_endpoint", c.TracingEndpoint)
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		v.addf("tracing_sample_ratio", "must be between 0 and 1, got %v", c.TracingSampleRatio)
+	}
+
+	// Access log
+	v.oneOf("access_log_format", c.AccessLogFormat, AccessLogCommon, AccessLogCombined, AccessLogJSON, AccessLogTemplate)
+	if c.AccessLogFormat == AccessLogTemplate {
+		if c.AccessLogTemplate == "" {
+			v.addf("access_log_template", "is required when access_log_format is %q", AccessLogTemplate)
+		} else if _, err := parseAccessLogTemplate(c.AccessLogTemplate); err != nil {
+			v.addf("access_log_template", "is not a valid template: %v", err)
+		}
+	}
+	if c.AccessLogPath == "" {
+		v.addf("access_log_path", "must be stdout, stderr or a file path")
+	}
+	v.atLeast("access_log_max_size_mb", c.AccessLogMaxSizeMB, 0)
+	v.atLeast("access_log_rotate_interval_seconds", c.AccessLogRotateInterval, 0)
+	v.atLeast("access_log_max_backups", c.AccessLogMaxBackups, 0)
+
+	// Health checks
+	v.oneOf("health_check_type", c.HealthCheckType, HealthCheckHTTP, HealthCheckTCP, HealthCheckGRPC)
+	if c.HealthCheckEnabled && c.HealthCheckType == HealthCheckGRPC {
+		if len(c.Backends) == 0 && len(c.Routes) == 0 {
+			v.grpcBackends("backend", []BackendConfig{{URL: c.Backend}}, "gRPC health checks")
+		}
+		v.grpcBackends("backends", c.Backends, "gRPC health checks")
+		for i, route := range c.Routes {
+			v.grpcBackends(fmt.Sprintf("routes[%d].backends", i), route.Backends, "gRPC health checks")
+		}
+	}
+	v.path("health_check_path", c.HealthCheckPath)
+	v.atLeast("health_check_interval_seconds", c.HealthCheckInterval, 1)
+	v.atLeast("health_check_timeout_seconds", c.HealthCheckTimeout, 1)
+	if c.HealthCheckExpectedStatus != 0 {
+		v.between("health_check_expected_status", c.HealthCheckExpectedStatus, 100, 599)
+	}
+	v.atLeast("health_check_rise", c.HealthCheckRise, 1)
+	v.atLeast("health_check_fall", c.HealthCheckFall, 1)
+
+	// Outlier detection
+	v.atLeast("outlier_consecutive_failures", c.OutlierConsecutiveFailures, 1)
+	v.atLeast("outlier_base_ejection_seconds", c.OutlierBaseEjection, 1)
+	v.atLeast("outlier_max_ejection_seconds", c.OutlierMaxEjection, c.OutlierBaseEjection)
+	v.atLeast("outlier_recovery_seconds", c.OutlierRecovery, 0)
+
+	// Circuit breakers
+	v.atLeast("circuit_breaker_consecutive_failures", c.CircuitBreakerConsecutiveFailures, 0)
+	v.between("circuit_breaker_error_rate_percent", c.CircuitBreakerErrorRate, 0, 100)
+	v.atLeast("circuit_breaker_min_requests", c.CircuitBreakerMinRequests, 1)
+	v.atLeast("circuit_breaker_window_seconds", c.CircuitBreakerWindow, 1)
+	v.atLeast("circuit_breaker_open_seconds", c.CircuitBreakerOpenTimeout, 1)
+	v.atLeast("circuit_breaker_half_open_requests", c.CircuitBreakerHalfOpenRequests, 1)
+	if c.CircuitBreakerConsecutiveFailures == 0 && c.CircuitBreakerErrorRate == 0 {
+		v.addf("circuit_breaker_error_rate_percent", "must be above 0 when circuit_breaker_consecutive_failures is 0")
+	}
+
+	// Retries
+	v.atLeast("retry_max_attempts", c.RetryMaxAttempts, 1)
+	for i, status := range c.RetryOnStatus {
+		v.between(fmt.Sprintf("retry_on_status[%d]", i), status, 100, 599)
+	}
+	v.atLeast("retry_backoff_base_ms", c.RetryBackoffBase, 0)
+	v.atLeast("retry_backoff_max_ms", c.RetryBackoffMax, c.RetryBackoffBase)
+	v.between("retry_budget_percent", c.RetryBudgetPercent, 0, 100)
+	v.atLeast("retry_budget_min_per_second", c.RetryBudgetMinPerSecond, 0)
+	if c.RetryMaxBodyBytes < 0 {
+		v.addf("retry_max_body_bytes", "must be at least 0, got %d", c.RetryMaxBodyBytes)
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
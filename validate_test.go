@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_ValidateDefaults(t *testing.T) {
+	assert.NoError(t, defaultConfig().Validate())
+}
+
+func TestConfig_ValidateCollectsAllErrors(t *testing.T) {
+	config := defaultConfig()
+	config.Port = -1
+	config.Backend = "not a url"
+	config.CacheSize = 0
+	config.LogLevel = "verbose"
+	config.LogFormat = "xml"
+	config.RetryOnStatus = []int{503, 700}
+
+	err := config.Validate()
+	assert.Error(t, err)
+
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	fields := []string{}
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	assert.ElementsMatch(t, []string{"port", "backend", "cache_size", "log_level", "log_format", "retry_on_status[1]"}, fields)
+	assert.Contains(t, err.Error(), "6 configuration error(s):")
+	assert.Contains(t, err.Error(), "port: must be between 1 and 65535, got -1")
+}
+
+func TestConfig_ValidateRoutes(t *testing.T) {
+	config := defaultConfig()
+	config.Routes = []RouteConfig{
+		{Name: "api", PathPrefix: "api/", Backends: []BackendConfig{{URL: "http://api:8080"}, {URL: "ftp://files"}}},
+		{Name: "api", Host: "*.example.com:8080", PathRegex: "(", Methods: []string{"GET POST"}},
+		{Name: "web", Backends: []BackendConfig{{URL: "http://web"}}, LoadBalancer: "fastest", Cache: &RouteCacheConfig{Size: -1}},
+	}
+
+	err := config.Validate()
+	assert.Error(t, err)
+
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	messages := map[string]string{}
+	for _, e := range errs {
+		messages[e.Field] = e.Message
+	}
+	assert.Contains(t, messages["routes[0].path_prefix"], `must start with "/"`)
+	assert.Contains(t, messages["routes[0].backends[1].url"], "must be an http or https URL")
+	assert.Contains(t, messages["routes[1].name"], "duplicates the name of routes[0]")
+	assert.Contains(t, messages["routes[1].host"], "without port")
+	assert.Contains(t, messages["routes[1].path_regex"], "not a valid regular expression")
+	assert.Contains(t, messages["routes[1].methods[0]"], "not a valid HTTP method")
+	assert.Contains(t, messages["routes[1].backends"], "at least one backend")
+	assert.Contains(t, messages["routes[2].load_balancer"], "must be one of")
+	assert.Contains(t, messages["routes[2].cache.size"], "must be at least 0")
+	assert.NotContains(t, messages, "backend")
+}
+
+func TestConfig_ValidateReservedRouteNames(t *testing.T) {
+	backends := []BackendConfig{{URL: "http://api:8080"}}
+	config := defaultConfig()
+	config.Routes = []RouteConfig{
+		{Name: "shared", Backends: backends},
+		{Name: "route-2", Backends: backends},
+		{Backends: backends},
+		{Name: "route-main", Backends: backends},
+	}
+
+	var errs ValidationErrors
+	assert.True(t, errors.As(config.Validate(), &errs))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "routes[0].name", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "reserved for the shared cache")
+	assert.Equal(t, "routes[1].name", errs[1].Field)
+	assert.Contains(t, errs[1].Message, "reserved for unnamed routes")
+}
+
+func TestConfig_ValidateCrossFieldRules(t *testing.T) {
+	config := defaultConfig()
+	config.AdminEnabled = true
+	config.AdminPort = config.Port
+	config.OutlierMaxEjection = config.OutlierBaseEjection - 1
+	config.CircuitBreakerConsecutiveFailures = 0
+	config.CircuitBreakerErrorRate = 0
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "admin_port: must differ from port 8080")
+	assert.ErrorContains(t, err, "outlier_max_ejection_seconds: must be at least 30")
+	assert.ErrorContains(t, err, "circuit_breaker_error_rate_percent: must be above 0")
+}
+
+func TestConfig_ValidateAccessLogTemplate(t *testing.T) {
+	config := defaultConfig()
+	config.AccessLogFormat = AccessLogTemplate
+	assert.ErrorContains(t, config.Validate(), "access_log_template: is required")
+
+	config.AccessLogTemplate = "{{.Missing}}"
+	assert.ErrorContains(t, config.Validate(), "access_log_template: is not a valid template")
+
+	config.AccessLogTemplate = "{{.ClientIP}} {{.Status}}"
+	assert.NoError(t, config.Validate())
+}
+
+func TestConfig_ValidateRequestID(t *testing.T) {
+	config := defaultConfig()
+	config.RequestIDHeader = "X Request ID"
+	config.RequestIDTrustedNetworks = []string{"10.0.0.0/8", "10.0.0.1"}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "request_id_header: is not a valid header name")
+	assert.ErrorContains(t, err, "request_id_trusted_networks[1]: must be a CIDR")
+
+	config.RequestIDHeader = ""
+	config.RequestIDTrustedNetworks = nil
+	assert.NoError(t, config.Validate())
+}
+
+func TestConfig_ValidateTracing(t *testing.T) {
+	config := defaultConfig()
+	config.TracingEnabled = true
+	config.TracingEndpoint = "collector:4318"
+	config.TracingSampleRatio = 1.5
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "tracing_endpoint: must be an http or https URL")
+	assert.ErrorContains(t, err, "tracing_sample_ratio: must be between 0 and 1, got 1.5")
+}
+
+func TestConfig_ValidateTLS(t *testing.T) {
+	config := defaultConfig()
+	config.TLSEnabled = true
+	config.HTTPRedirectEnabled = true
+	config.HTTPRedirectPort = config.Port
+	config.TLSMinVersion = "1.4"
+	config.TLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "tls_min_version: must be one of")
+	assert.ErrorContains(t, err, `tls_cipher_suites[0]: is not a supported cipher suite: "TLS_RSA_WITH_RC4_128_SHA"`)
+	assert.ErrorContains(t, err, "tls_cert_file: is required when tls_enabled is set")
+	assert.ErrorContains(t, err, "http_redirect_port: must differ from port 8080")
+
+	config = defaultConfig()
+	config.TLSEnabled = true
+	config.TLSCertFile = "missing.crt"
+	config.TLSCertificates = []TLSCertificateConfig{writeTestCertificate(t, t.TempDir(), "api", "api.example.com"), {CertFile: "other.crt"}}
+	err = config.Validate()
+	assert.ErrorContains(t, err, "tls_key_file: is required")
+	assert.ErrorContains(t, err, "tls_certificates[1].key_file: is required")
+	assert.NotContains(t, err.Error(), "tls_certificates[0]")
+
+	config = defaultConfig()
+	config.HTTPRedirectEnabled = true
+	assert.ErrorContains(t, config.Validate(), "http_redirect_enabled: requires tls_enabled")
+}
+
+func TestConfig_ValidateMutualTLS(t *testing.T) {
+	config := defaultConfig()
+	config.TLSClientAuth = ClientAuthRequire
+	config.BackendTLSCAFile = "missing-ca.pem"
+	config.BackendTLSCertFile = "client.crt"
+	config.Routes = []RouteConfig{{
+		Backends: []BackendConfig{{URL: "https://api:8443", TLS: &BackendTLSConfig{KeyFile: "api.key"}}},
+	}}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "tls_client_auth: requires tls_enabled")
+	assert.ErrorContains(t, err, "backend_tls_ca_file: failed to load CA bundle")
+	assert.ErrorContains(t, err, "backend_tls_key_file: is required")
+	assert.ErrorContains(t, err, "routes[0].backends[0].tls.cert_file: is required")
+
+	config = defaultConfig()
+	config.TLSEnabled = true
+	config.TLSCertificates = []TLSCertificateConfig{writeTestCertificate(t, t.TempDir(), "proxy", "example.com")}
+	config.TLSClientAuth = ClientAuthOptional
+	config.Routes = []RouteConfig{{ClientCertRequired: true, Backends: []BackendConfig{{URL: "http://api:8080"}}}}
+	assert.ErrorContains(t, config.Validate(), `tls_client_ca_file: is required when tls_client_auth is "optional"`)
+
+	config.TLSClientAuth = ClientAuthNone
+	assert.ErrorContains(t, config.Validate(), `routes[0].client_cert_required: requires tls_client_auth to be "optional" or "require"`)
+}
+
+func TestConfig_ValidateACME(t *testing.T) {
+	config := defaultConfig()
+	config.ACMEEnabled = true
+	config.ACMEDomains = []string{"*.example.com", "example.com:443"}
+	config.ACMECacheDir = ""
+	config.HTTPRedirectPort = config.Port
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "acme_enabled: requires tls_enabled")
+	assert.ErrorContains(t, err, `acme_domains[0]: must be a host name without wildcard, port or path, got "*.example.com"`)
+	assert.ErrorContains(t, err, `acme_domains[1]: must be a host name without wildcard, port or path, got "example.com:443"`)
+	assert.ErrorContains(t, err, "acme_cache_dir: is required")
+	assert.ErrorContains(t, err, "http_redirect_port: must differ from port 8080")
+
+	// ACME replaces the certificate files
+	config = defaultConfig()
+	config.TLSEnabled = true
+	config.ACMEEnabled = true
+	config.ACMEDomains = []string{"example.com"}
+	assert.NoError(t, config.Validate())
+}
+
+func TestConfig_ValidateStreamIdleTimeout(t *testing.T) {
+	config := defaultConfig()
+	config.StreamIdleTimeout = -1
+	config.Routes = []RouteConfig{
+		{Name: "events", PathPrefix: "/events", Backends: []BackendConfig{{URL: "http://events"}}, Timeout: &RouteTimeoutConfig{StreamIdleSeconds: -5}},
+	}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "stream_idle_timeout_seconds: must be at least 0, got -1")
+	assert.ErrorContains(t, err, "routes[0].timeout.stream_idle_seconds: must be at least 0, got -5")
+}
+
+func TestConfig_ValidateHTTP2(t *testing.T) {
+	config := defaultConfig()
+	config.TLSEnabled = true
+	config.TLSCertFile, config.TLSKeyFile = "missing.crt", "missing.key"
+	config.H2CEnabled = true
+	config.HTTP2MaxConcurrentStreams = 0
+	config.HTTP2MaxConnectionWindow = 1024
+	config.Backends = []BackendConfig{
+		{URL: "http://a.internal", Protocol: BackendProtocolHTTP2},
+		{URL: "https://b.internal", Protocol: BackendProtocolH2C},
+		{URL: "http://c.internal", Protocol: "spdy"},
+		{URL: "http://d.internal", Protocol: BackendProtocolH2C},
+	}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "h2c_enabled: requires tls_enabled to be false")
+	assert.ErrorContains(t, err, "http2_max_concurrent_streams: must be at least 1, got 0")
+	assert.ErrorContains(t, err, "http2_max_connection_window_bytes: must be between 65535 and 2147483647, got 1024")
+	assert.ErrorContains(t, err, "backends[0].protocol: http2 requires an https URL")
+	assert.ErrorContains(t, err, "backends[1].protocol: h2c requires an http URL")
+	assert.ErrorContains(t, err, `backends[2].protocol: must be one of auto, http1, http2, h2c, got "spdy"`)
+	assert.NotContains(t, err.Error(), "backends[3]")
+}
+
+func TestConfig_ValidateGRPC(t *testing.T) {
+	config := defaultConfig()
+	config.HealthCheckEnabled = true
+	config.HealthCheckType = HealthCheckGRPC
+	config.Routes = []RouteConfig{
+		{Name: "users", GRPCService: "users.v1.Users", PathPrefix: "/users", Backends: []BackendConfig{{URL: "http://users", Protocol: BackendProtocolH2C}}},
+		{Name: "orders", GRPCService: "orders service", GRPCMethod: "Get-Order", Backends: []BackendConfig{{URL: "http://orders"}}},
+		{Name: "billing", GRPCMethod: "Charge", Backends: []BackendConfig{{URL: "https://billing", Protocol: BackendProtocolHTTP1}}},
+		{Name: "web", PathPrefix: "/", Backends: []BackendConfig{{URL: "https://web"}}},
+	}
+
+	err := config.Validate()
+	assert.ErrorContains(t, err, "routes[0].grpc_service: cannot be combined with path, path_prefix or path_regex")
+	assert.ErrorContains(t, err, `routes[1].grpc_service: must be a fully-qualified service name such as "package.Service", got "orders service"`)
+	assert.ErrorContains(t, err, `routes[1].grpc_method: must be a method name such as "GetUser", got "Get-Order"`)
+	assert.ErrorContains(t, err, "routes[1].backends[0].protocol: gRPC routes require HTTP/2")
+	assert.ErrorContains(t, err, "routes[2].grpc_method: requires grpc_service")
+	assert.ErrorContains(t, err, "routes[2].backends[0].protocol: gRPC health checks require HTTP/2")
+	assert.NotContains(t, err.Error(), "routes[0].backends")
+	assert.NotContains(t, err.Error(), "routes[3]")
+}